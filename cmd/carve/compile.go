@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"carve/pkg/carve"
+)
+
+// compile implements `carve compile`, which writes a scanner's plan to a
+// file that --plan loads without recompiling the pattern.
+func compile(args []string) {
+	fs := flag.NewFlagSet("compile", flag.ExitOnError)
+	var src source
+	src.register(fs)
+	output := fs.String("output", "", "plan file to write")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s compile --pattern <regex> | --grok <grok> | --template <template> --output <plan>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ok, err := src.resolve()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok || *output == "" {
+		fmt.Fprintf(os.Stderr, "Error: a pattern and --output are required\n\n")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	s, err := src.scanner()
+	if err != nil {
+		log.Fatal(err)
+	}
+	data, err := s.WithOptions(carve.Options{ZeroCopy: true, Verify: true}).MarshalBinary()
+	if err != nil {
+		log.Fatalf("failed to encode plan: %v", err)
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		log.Fatalf("failed to write plan: %v", err)
+	}
+	fmt.Printf("wrote %s plan for %d fields (plan version %d) to %s\n", s.Mode(), len(s.Schema().Fields()), carve.PlanVersion, *output)
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"carve/pkg/carve"
+)
+
+// explain implements `carve explain`, which prints the scan plan a
+// pattern compiles to.
+func explain(args []string) {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	var src source
+	src.register(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s explain --pattern <regex> | --grok <grok> | --template <template> | --plan <plan>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ok, err := src.resolve()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: --pattern, --grok, --template or --plan flag is required\n\n")
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	s, err := src.scanner()
+	if err != nil {
+		log.Fatal(err)
+	}
+	kind, text := src.describe()
+	printPlan(os.Stdout, kind, text, s.Plan())
+}
+
+func printPlan(w io.Writer, kind, source string, plan carve.Plan) {
+	fmt.Fprintf(w, "%-8s %s\n", kind+":", source)
+	fmt.Fprintf(w, "Mode:    %s\n\n", plan.Mode)
+	printSequence(w, plan.PlanSequence, "")
+
+	if len(plan.Warnings) > 0 {
+		fmt.Fprintf(w, "\nWarnings:\n")
+		for _, warn := range plan.Warnings {
+			fmt.Fprintf(w, "  - %s\n", warn)
+		}
+	}
+}
+
+func printSequence(w io.Writer, seq carve.PlanSequence, indent string) {
+	if seq.Prefix != "" {
+		fmt.Fprintf(w, "%sprefix %q\n", indent, seq.Prefix)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, f := range seq.Fields {
+		if f.Terminator == "branch" {
+			tw.Flush()
+			fmt.Fprintf(w, "%sbranch\n", indent)
+			for j, alt := range f.Alternatives {
+				fmt.Fprintf(w, "%s  alternative %d:\n", indent, j+1)
+				printSequence(w, alt, indent+"    ")
+			}
+			continue
+		}
+		col, name := fmt.Sprint(f.Column), f.Name
+		if f.Column < 0 {
+			col, name = "-", "_"
+		}
+		fmt.Fprintf(tw, "%s%s\t%s\t%s\t%s\n", indent, col, name, terminator(f), fieldDetails(f))
+	}
+	tw.Flush()
+
+	if seq.Suffix != "" {
+		fmt.Fprintf(w, "%ssuffix %q\n", indent, seq.Suffix)
+	}
+	if seq.AnchorEnd {
+		fmt.Fprintf(w, "%send of line\n", indent)
+	}
+}
+
+func terminator(f carve.FieldPlan) string {
+	if f.SpaceAfter != "" {
+		return separator(f) + " " + f.SpaceAfter
+	}
+	return separator(f)
+}
+
+func separator(f carve.FieldPlan) string {
+	switch f.Terminator {
+	case "delimiter":
+		return fmt.Sprintf("until %q", f.Delimiter)
+	case "space":
+		if f.Delimiter == "" {
+			return "until " + f.Space
+		}
+		return fmt.Sprintf("until %s %q", f.Space, f.Delimiter)
+	case "class":
+		return "while in class"
+	case "bracket":
+		if f.Delimiter == "" {
+			return fmt.Sprintf("between %q and %q", f.Brackets[:1], f.Brackets[1:])
+		}
+		return fmt.Sprintf("between %q and %q, then %q", f.Brackets[:1], f.Brackets[1:], f.Delimiter)
+	case "quote":
+		if f.Enclosed {
+			return fmt.Sprintf("quoted by %q, then %q", f.Quote, f.Delimiter)
+		}
+		return fmt.Sprintf("until unescaped %q", f.Delimiter)
+	case "suffix":
+		return "until suffix"
+	default:
+		return "rest of line"
+	}
+}
+
+func fieldDetails(f carve.FieldPlan) string {
+	var parts []string
+	if f.Class != "" {
+		parts = append(parts, "class "+f.Class)
+	}
+	if f.Verify != "" {
+		parts = append(parts, "verify "+f.Verify)
+	}
+	if f.Escape != "" {
+		parts = append(parts, "escape "+f.Escape)
+	}
+	if f.Min > 0 {
+		parts = append(parts, fmt.Sprintf("min %d", f.Min))
+	}
+	if f.Max >= 0 {
+		parts = append(parts, fmt.Sprintf("max %d", f.Max))
+	}
+	if f.Optional {
+		parts = append(parts, "optional")
+	}
+	if f.Last {
+		parts = append(parts, "last")
+	}
+	return strings.Join(parts, "  ")
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 
+	"github.com/apache/arrow-go/v18/arrow/array"
 	"github.com/apache/arrow-go/v18/arrow/ipc"
 	"github.com/apache/arrow-go/v18/arrow/memory"
 )
@@ -91,6 +96,305 @@ func TestCLI_StdinInput(t *testing.T) {
 	validateArrowFile(t, tmp.Name())
 }
 
+func TestCLI_LongLines(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "long.log"), filepath.Join(dir, "out.arrow")
+	// bufio.Scanner stopped at 64KB lines; CRLF line ends are still
+	// stripped.
+	msg := strings.Repeat("x", 100<<10)
+	data := "2023-01-01T10:00:00Z INFO " + msg + "\r\n2023-01-01T10:00:01Z WARN short\r\n"
+	if err := os.WriteFile(in, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("go", "run", ".", "--template", "{ts} {level} {msg...}", "--input", in, "--output", out)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("run failed: %v: %s", err, output)
+	}
+
+	f := mustOpen(t, out)
+	defer f.Close()
+	reader, err := ipc.NewFileReader(f, ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	rec, err := reader.Record(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.NumRows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", rec.NumRows())
+	}
+	col := rec.Column(2).(*array.Binary)
+	if string(col.Value(0)) != msg || string(col.Value(1)) != "short" {
+		t.Errorf("msg column holds %d and %q", len(col.Value(0)), col.Value(1))
+	}
+}
+
+func TestCLI_LongLinePolicy(t *testing.T) {
+	dir := t.TempDir()
+	in, out, rejects := filepath.Join(dir, "in.log"), filepath.Join(dir, "out.arrow"), filepath.Join(dir, "rejects.log")
+	long := "2023-01-01T10:00:01Z WARN " + strings.Repeat("x", 200)
+	data := "2023-01-01T10:00:00Z INFO started\n" + long + "\n2023-01-01T10:00:02Z INFO done\n"
+	if err := os.WriteFile(in, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) ([]byte, error) {
+		args = append([]string{"run", ".", "--template", "{ts} {level} {msg...}", "--input", in, "--output", out, "--max-line-length", "100"}, args...)
+		return exec.Command("go", args...).CombinedOutput()
+	}
+
+	if output, err := run(); err == nil || !strings.Contains(string(output), "line 2 is longer than --max-line-length 100") {
+		t.Fatalf("expected the default policy to fail on line 2: %v: %s", err, output)
+	}
+
+	if output, err := run("--long-lines", "truncate"); err != nil {
+		t.Fatalf("truncate run failed: %v: %s", err, output)
+	}
+	f := mustOpen(t, out)
+	defer f.Close()
+	reader, err := ipc.NewFileReader(f, ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	rec, err := reader.Record(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.NumCols() != 4 || rec.Schema().Field(3).Name != "_truncated" {
+		t.Fatalf("unexpected schema %v", rec.Schema())
+	}
+	flags := rec.Column(3).(*array.Boolean)
+	if rec.NumRows() != 3 || flags.Value(0) || !flags.Value(1) || flags.Value(2) {
+		t.Errorf("truncated column is %v", flags)
+	}
+	if msg := rec.Column(2).(*array.Binary).Value(1); len(msg) != 100-len("2023-01-01T10:00:01Z WARN ") {
+		t.Errorf("truncated msg has %d bytes", len(msg))
+	}
+
+	if output, err := run("--long-lines", "reject", "--reject-file", rejects); err != nil {
+		t.Fatalf("reject run failed: %v: %s", err, output)
+	}
+	if got, err := os.ReadFile(rejects); err != nil || string(got) != long+"\n" {
+		t.Errorf("reject file holds %q (%v)", got, err)
+	}
+	if output, err := run("--long-lines", "reject"); err == nil || !strings.Contains(string(output), "requires --reject-file") {
+		t.Errorf("expected reject without a file to fail: %v: %s", err, output)
+	}
+}
+
+func TestCLI_TruncatedRowsAcrossBatches(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.log"), filepath.Join(dir, "out.arrow")
+	long := "2023-01-01T10:00:01Z WARN " + strings.Repeat("x", 200)
+	data := "2023-01-01T10:00:00Z INFO started\nmalformed\n" + long + "\n" + long + "\n2023-01-01T10:00:02Z INFO done\n2023-01-01T10:00:03Z INFO late\n"
+	if err := os.WriteFile(in, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Rejected lines leave no flag behind, and flags stay with their rows
+	// across batches and up to --max-rows.
+	cmd := exec.Command("go", "run", ".", "--template", "{ts} {level} {msg...}", "--input", in, "--output", out,
+		"--max-line-length", "100", "--long-lines", "truncate", "--flush-interval", "2", "--max-rows", "4")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("run failed: %v: %s", err, output)
+	}
+
+	f := mustOpen(t, out)
+	defer f.Close()
+	reader, err := ipc.NewFileReader(f, ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	var got []string
+	for i := 0; i < reader.NumRecords(); i++ {
+		rec, err := reader.Record(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.NumRows() != 2 {
+			t.Errorf("record %d has %d rows", i, rec.NumRows())
+		}
+		msgs, flags := rec.Column(2).(*array.Binary), rec.Column(3).(*array.Boolean)
+		for r := 0; r < int(rec.NumRows()); r++ {
+			got = append(got, fmt.Sprintf("%.4s:%v", msgs.Value(r), flags.Value(r)))
+		}
+	}
+	if want := []string{"star:false", "xxxx:true", "xxxx:true", "done:false"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rows are %v, want %v", got, want)
+	}
+}
+
+func TestCLI_Records(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "trace.log"), filepath.Join(dir, "out.arrow")
+	trace := "request failed\njava.lang.IllegalStateException: boom\n\tat com.example.Handler.handle(Handler.java:42)"
+	data := "2023-01-01T10:00:00Z INFO started\n2023-01-01T10:00:01Z ERROR " + trace + "\n2023-01-01T10:00:02Z INFO done\n"
+	if err := os.WriteFile(in, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	sources := [][]string{
+		{"--template", "{ts} {level} {msg...}"},
+		{"--pattern", `^(?P<ts>\d{4}-[^ ]+) (?P<level>\w+) (?P<msg>.+)`},
+	}
+	for _, src := range sources {
+		args := append([]string{"run", "."}, src...)
+		args = append(args, "--record-start", `^\d{4}-`, "--input", in, "--output", out)
+		if output, err := exec.Command("go", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%s run failed: %v: %s", src[0], err, output)
+		}
+
+		f := mustOpen(t, out)
+		reader, err := ipc.NewFileReader(f, ipc.WithAllocator(memory.DefaultAllocator))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := reader.Record(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rec.NumRows() != 3 {
+			t.Errorf("%s: expected 3 rows, got %d", src[0], rec.NumRows())
+		} else if msg := rec.Column(2).(*array.Binary).Value(1); string(msg) != trace {
+			t.Errorf("%s: msg = %q, want the whole trace", src[0], msg)
+		}
+		reader.Close()
+		f.Close()
+	}
+
+	cmd := exec.Command("go", "run", ".", "--template", "{ts} {level} {msg...}", "--record-start", "x", "--record-indented", "--input", in, "--output", out)
+	if output, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(output), "only one of --record-start") {
+		t.Errorf("expected conflicting record rules to fail: %v: %s", err, output)
+	}
+}
+
+func TestCLI_Types(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.log"), filepath.Join(dir, "out.arrow")
+	if err := os.WriteFile(in, []byte("200 0.25 GET\n404 - POST\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	sources := [][]string{
+		{"--pattern", `^(?P<status__int32>\d+) (?P<took>\S+) (?P<method>\w+)$`, "--types", "took=float64"},
+		{"--template", "{status__int32} {took__float64} {method}"},
+		{"--template", "{status} {took} {method}", "--types", "status=int32, took=float64"},
+	}
+	for _, src := range sources {
+		args := append([]string{"run", ".", "--input", in, "--output", out}, src...)
+		if b, err := exec.Command("go", args...).CombinedOutput(); err != nil {
+			t.Fatalf("%q: run failed: %v: %s", src, err, b)
+		}
+
+		f := mustOpen(t, out)
+		reader, err := ipc.NewFileReader(f, ipc.WithAllocator(memory.DefaultAllocator))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rec, err := reader.Record(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// an unparsable value is null
+		status, ok1 := rec.Column(0).(*array.Int32)
+		took, ok2 := rec.Column(1).(*array.Float64)
+		if !ok1 || !ok2 || status.String() != "[200 404]" || took.String() != "[0.25 (null)]" {
+			t.Errorf("%q: columns %v and %v", src, rec.Column(0), rec.Column(1))
+		}
+		reader.Close()
+		f.Close()
+	}
+
+	b, err := exec.Command("go", "run", ".", "--template", "{status} {took}", "--types", "took=decimal", "--schema").CombinedOutput()
+	if err == nil || !strings.Contains(string(b), `unknown type "decimal" for field "took"`) {
+		t.Fatalf("expected an unknown type error: %v: %s", err, b)
+	}
+}
+
+func TestCLI_Timestamps(t *testing.T) {
+	dir := t.TempDir()
+	in, out, plan := filepath.Join(dir, "in.log"), filepath.Join(dir, "out.arrow"), filepath.Join(dir, "app.plan")
+	data := "2023-01-01T10:00:00.250Z [01/Jan/2023:11:00:00 +0100] started\nnow [yesterday] done\n"
+	if err := os.WriteFile(in, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	template := "{iso__timestamp} [{clf}] {msg...}"
+	timestamp := []string{"--timestamp", "clf=%d/%b/%Y:%H:%M:%S %z", "--time-unit", "ms"}
+	args := append([]string{"run", ".", "--template", template, "--input", in, "--output", out}, timestamp...)
+	if b, err := exec.Command("go", args...).CombinedOutput(); err != nil {
+		t.Fatalf("run failed: %v: %s", err, b)
+	}
+
+	f := mustOpen(t, out)
+	defer f.Close()
+	reader, err := ipc.NewFileReader(f, ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	rec, err := reader.Record(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iso, ok1 := rec.Column(0).(*array.Timestamp)
+	clf, ok2 := rec.Column(1).(*array.Timestamp)
+	if !ok1 || !ok2 {
+		t.Fatalf("columns are %s and %s", rec.Column(0).DataType(), rec.Column(1).DataType())
+	}
+	// values that do not parse are null
+	if iso.Value(0) != 1672567200250000 || clf.Value(0) != 1672567200000 || iso.IsValid(1) || clf.IsValid(1) {
+		t.Errorf("columns %v and %v", iso, clf)
+	}
+
+	// Plans keep the timestamp columns.
+	args = append([]string{"run", ".", "compile", "--template", template, "--output", plan}, timestamp...)
+	if b, err := exec.Command("go", args...).CombinedOutput(); err != nil {
+		t.Fatalf("compile failed: %v: %s", err, b)
+	}
+	b, err := exec.Command("go", "run", ".", "--plan", plan, "--schema").CombinedOutput()
+	if err != nil {
+		t.Fatalf("schema failed: %v: %s", err, b)
+	}
+	if !strings.Contains(string(b), "0: iso (timestamp[us, tz=UTC])") || !strings.Contains(string(b), "1: clf (timestamp[ms, tz=UTC])") {
+		t.Fatalf("unexpected plan schema: %s", b)
+	}
+
+	b, err = exec.Command("go", "run", ".", "--template", template, "--timestamp", "clf=%Q", "--schema").CombinedOutput()
+	if err == nil || !strings.Contains(string(b), "unknown strftime directive %Q") {
+		t.Fatalf("expected a layout error: %v: %s", err, b)
+	}
+}
+
+func TestCLI_UnnamedGroups(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in.log"), filepath.Join(dir, "out.arrow")
+	if err := os.WriteFile(in, []byte("h u\nx y\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Unnamed groups capture but are not columns.
+	cmd := exec.Command("go", "run", ".", "--pattern", `^(\S+) (?P<a>\S+)$`, "--input", in, "--output", out)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("run failed: %v: %s", err, b)
+	}
+
+	f := mustOpen(t, out)
+	defer f.Close()
+	reader, err := ipc.NewFileReader(f, ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	rec, err := reader.Record(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.NumCols() != 1 || rec.Column(0).String() != `["u" "y"]` {
+		t.Fatalf("record %v", rec)
+	}
+}
+
 func TestCLI_FlushInterval(t *testing.T) {
 	tmp, err := os.CreateTemp("", "out.arrow")
 	if err != nil {
@@ -108,6 +412,173 @@ func TestCLI_FlushInterval(t *testing.T) {
 	validateArrowFile(t, tmp.Name())
 }
 
+func TestCLI_Explain(t *testing.T) {
+	cmd := exec.Command("go", "run", ".", "explain", "--pattern", `^(?P<ts>[^ ]+) (?P<level>\w+) (?P<msg>.+)`)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("explain failed: %v: %s", err, out)
+	}
+
+	output := string(out)
+	if !regexp.MustCompile(`Mode:\s+compiled`).MatchString(output) {
+		t.Fatalf("expected compiled mode in explain output: %s", output)
+	}
+	if !regexp.MustCompile(`0\s+ts\s+until " "\s+class \[\^ \]`).MatchString(output) {
+		t.Fatalf("expected ts instruction in explain output: %s", output)
+	}
+	if !regexp.MustCompile(`2\s+msg\s+rest of line`).MatchString(output) {
+		t.Fatalf("expected msg instruction in explain output: %s", output)
+	}
+}
+
+func TestCLI_ExplainWarnings(t *testing.T) {
+	cmd := exec.Command("go", "run", ".", "explain", "--pattern", `^(?:(?P<kv>\w+=\w+) )+(?P<msg>.*)`)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("explain failed: %v: %s", err, out)
+	}
+
+	output := string(out)
+	if !regexp.MustCompile(`Mode:\s+regexp`).MatchString(output) {
+		t.Fatalf("expected regexp mode in explain output: %s", output)
+	}
+	if !regexp.MustCompile(`Warnings:\n  - `).MatchString(output) {
+		t.Fatalf("expected warnings in explain output: %s", output)
+	}
+}
+
+func TestCLI_Grok(t *testing.T) {
+	dir := t.TempDir()
+	patterns := filepath.Join(dir, "app")
+	if err := os.WriteFile(patterns, []byte("# application log\nAPPLEVEL INFO|WARN|ERROR|DEBUG\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "out.arrow")
+
+	cmd := exec.Command("go", "run", ".",
+		"--grok", `^%{TIMESTAMP_ISO8601:ts} %{APPLEVEL:level} %{GREEDYDATA:msg}`,
+		"--grok-patterns", dir,
+		"--input", "../../testdata/sample.log", "--output", out)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("grok run failed: %v: %s", err, b)
+	}
+	validateArrowFile(t, out)
+
+	cmd = exec.Command("go", "run", ".", "--grok", `^%{IPORHOST:client} %{NUMBER:bytes:int}$`, "--schema")
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("grok schema failed: %v: %s", err, b)
+	}
+	if !regexp.MustCompile(`Schema \(2 fields\):\n  0: client .*\n  1: bytes \(int64\)`).Match(b) {
+		t.Fatalf("unexpected grok schema output: %s", b)
+	}
+	// --types overrides a type hint.
+	cmd = exec.Command("go", "run", ".", "--grok", `^%{IPORHOST:client} %{NUMBER:bytes:int}$`, "--types", "bytes=uint32", "--schema")
+	if b, err = cmd.CombinedOutput(); err != nil || !strings.Contains(string(b), "1: bytes (uint32)") {
+		t.Fatalf("grok schema with --types: %v: %s", err, b)
+	}
+
+	// Grok patterns are compiled like any other.
+	cmd = exec.Command("go", "run", ".", "explain", "--grok", `^%{IPORHOST:client} %{NUMBER:bytes:int}$`)
+	if b, err = cmd.CombinedOutput(); err != nil {
+		t.Fatalf("grok explain failed: %v: %s", err, b)
+	}
+	if !regexp.MustCompile(`^Grok:\s+\^%\{IPORHOST:client\}.*\nMode:\s+compiled`).Match(b) {
+		t.Fatalf("unexpected grok explain output: %s", b)
+	}
+}
+
+func TestCLI_GrokPartialPlan(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "app.log"), filepath.Join(dir, "out.arrow")
+	// The timestamp holds the space the plan splits on, so the plan is
+	// only partial; the line must still be kept.
+	if err := os.WriteFile(in, []byte("2023-01-01 10:00:00 INFO hello world\nnot a log line\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command("go", "run", ".",
+		"--grok", `^%{TIMESTAMP_ISO8601:ts} %{LOGLEVEL:level} %{GREEDYDATA:msg}$`,
+		"--input", in, "--output", out)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("grok run failed: %v: %s", err, b)
+	}
+
+	f := mustOpen(t, out)
+	defer f.Close()
+	reader, err := ipc.NewFileReader(f, ipc.WithAllocator(memory.DefaultAllocator))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	rec, err := reader.Record(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.NumRows() != 1 {
+		t.Fatalf("expected 1 row, got %d", rec.NumRows())
+	}
+	ts, msg := rec.Column(0).(*array.Binary), rec.Column(2).(*array.Binary)
+	if string(ts.Value(0)) != "2023-01-01 10:00:00" || string(msg.Value(0)) != "hello world" {
+		t.Errorf("got ts %q and msg %q", ts.Value(0), msg.Value(0))
+	}
+}
+
+func TestCLI_Template(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.arrow")
+	cmd := exec.Command("go", "run", ".", "--template", "{ts}Z {level} {msg...}", "--input", "../../testdata/sample.log", "--output", out)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("template run failed: %v: %s", err, b)
+	}
+	validateArrowFile(t, out)
+
+	cmd = exec.Command("go", "run", ".", "explain", "--template", "{ts} {level} {msg...}")
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("explain failed: %v: %s", err, b)
+	}
+	output := string(b)
+	if !regexp.MustCompile(`Template: \{ts\} \{level\} \{msg\.\.\.\}\nMode:\s+compiled`).MatchString(output) {
+		t.Fatalf("unexpected explain output: %s", output)
+	}
+	if !regexp.MustCompile(`1\s+level\s+until " "`).MatchString(output) {
+		t.Fatalf("expected level instruction in explain output: %s", output)
+	}
+}
+
+func TestCLI_CompilePlan(t *testing.T) {
+	dir := t.TempDir()
+	plan := filepath.Join(dir, "app.plan")
+	cmd := exec.Command("go", "run", ".", "compile", "--pattern", `^(?P<ts>\d{4}-[^ ]+) (?P<level>\w+) (?P<msg>.+)`, "--output", plan)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("compile failed: %v: %s", err, b)
+	}
+
+	out := filepath.Join(dir, "out.arrow")
+	cmd = exec.Command("go", "run", ".", "--plan", plan, "--input", "../../testdata/sample.log", "--output", out)
+	if b, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("plan run failed: %v: %s", err, b)
+	}
+	validateArrowFile(t, out)
+
+	cmd = exec.Command("go", "run", ".", "explain", "--plan", plan)
+	b, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("explain failed: %v: %s", err, b)
+	}
+	if !regexp.MustCompile(`Plan:\s+.*app\.plan\nMode:\s+compiled`).Match(b) {
+		t.Fatalf("unexpected explain output: %s", b)
+	}
+
+	if err := os.WriteFile(plan, []byte("garbage"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cmd = exec.Command("go", "run", ".", "--plan", plan, "--schema")
+	if b, err := cmd.CombinedOutput(); err == nil || !regexp.MustCompile(`not a serialized scan plan`).Match(b) {
+		t.Fatalf("expected a load error, got %v: %s", err, b)
+	}
+}
+
 func TestCLI_ErrorHandling(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -129,6 +600,26 @@ func TestCLI_ErrorHandling(t *testing.T) {
 			args:    []string{"--pattern", "[invalid", "--output", "test.arrow"},
 			wantErr: true,
 		},
+		{
+			name:    "unknown grok pattern",
+			args:    []string{"--grok", "%{NOSUCH:a}", "--output", "test.arrow"},
+			wantErr: true,
+		},
+		{
+			name:    "pattern and grok",
+			args:    []string{"--pattern", "(?P<a>\\w+)", "--grok", "%{WORD:a}", "--output", "test.arrow"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid template",
+			args:    []string{"--template", "{a}{b}", "--output", "test.arrow"},
+			wantErr: true,
+		},
+		{
+			name:    "pattern and template",
+			args:    []string{"--pattern", "(?P<a>\\w+)", "--template", "{a}", "--output", "test.arrow"},
+			wantErr: true,
+		},
 		{
 			name:    "pattern without named groups",
 			args:    []string{"--pattern", "(\\w+)", "--output", "test.arrow"},
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+
+	"carve/pkg/carve"
+)
+
+// lineLimit holds the flags that bound line length and say what happens
+// to longer lines.
+type lineLimit struct {
+	max       int
+	policy    string
+	reject    string
+	truncated string
+}
+
+func (ll *lineLimit) register(fs *flag.FlagSet) {
+	fs.IntVar(&ll.max, "max-line-length", 0, "longest line in bytes, without its newline (0 = unlimited)")
+	fs.StringVar(&ll.policy, "long-lines", "error", "what to do with longer lines: error, truncate, skip or reject")
+	fs.StringVar(&ll.reject, "reject-file", "", "file the lines --long-lines=reject drops are written to")
+	fs.StringVar(&ll.truncated, "truncated-column", "_truncated", "name of the boolean column flagging rows cut by --long-lines=truncate or the record limits")
+}
+
+// options opens the reject file if there is one and returns the options
+// for the line reader; the caller closes the file.
+func (ll *lineLimit) options() (carve.LineOptions, *os.File, error) {
+	opts := carve.LineOptions{MaxLength: ll.max}
+	switch ll.policy {
+	case "error":
+		opts.Policy = carve.LongLineError
+	case "truncate":
+		opts.Policy = carve.LongLineTruncate
+	case "skip":
+		opts.Policy = carve.LongLineSkip
+	case "reject":
+		opts.Policy = carve.LongLineReject
+	default:
+		return opts, nil, fmt.Errorf("unknown --long-lines policy %q", ll.policy)
+	}
+	if ll.max < 0 {
+		return opts, nil, fmt.Errorf("--max-line-length must not be negative")
+	}
+	if opts.Policy != carve.LongLineReject {
+		return opts, nil, nil
+	}
+	if ll.reject == "" {
+		return opts, nil, fmt.Errorf("--long-lines=reject requires --reject-file")
+	}
+	f, err := os.Create(ll.reject)
+	if err != nil {
+		return opts, nil, fmt.Errorf("failed to create reject file: %w", err)
+	}
+	opts.Reject = f
+	return opts, f, nil
+}
+
+// flagsTruncation reports whether rows get a truncated column.
+func (ll *lineLimit) flagsTruncation() bool {
+	return ll.max > 0 && ll.policy == "truncate"
+}
+
+// extendSchema adds the truncated column to schema.
+func (ll *lineLimit) extendSchema(schema *arrow.Schema) (*arrow.Schema, error) {
+	if _, ok := schema.FieldsByName(ll.truncated); ok {
+		return nil, fmt.Errorf("--truncated-column %q is already a field of the pattern", ll.truncated)
+	}
+	fields := append(slices.Clip(schema.Fields()), arrow.Field{Name: ll.truncated, Type: arrow.FixedWidthTypes.Boolean})
+	return arrow.NewSchema(fields, nil), nil
+}
+
+// truncationColumn collects the truncated flags of the rows of a batch
+// and appends them to its record.
+type truncationColumn struct {
+	schema  *arrow.Schema
+	builder *array.BooleanBuilder
+}
+
+func newTruncationColumn(schema *arrow.Schema, mem memory.Allocator) *truncationColumn {
+	return &truncationColumn{schema: schema, builder: array.NewBooleanBuilder(mem)}
+}
+
+// extend returns rec with the flags appended since the last call as its
+// last column, releasing rec.
+func (tc *truncationColumn) extend(rec arrow.Record) arrow.Record {
+	flags := tc.builder.NewArray()
+	defer flags.Release()
+	defer rec.Release()
+	cols := append(slices.Clip(rec.Columns()), flags)
+	return array.NewRecord(tc.schema, cols, rec.NumRows())
+}
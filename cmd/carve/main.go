@@ -1,12 +1,11 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
-	"regexp"
 	"time"
 
 	"github.com/apache/arrow-go/v18/arrow"
@@ -19,7 +18,23 @@ import (
 const version = "0.2.0"
 
 func main() {
-	pattern := flag.String("pattern", "", "regex pattern with named capture groups")
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "explain":
+			explain(os.Args[2:])
+			return
+		case "compile":
+			compile(os.Args[2:])
+			return
+		}
+	}
+
+	var src source
+	src.register(flag.CommandLine)
+	var limit lineLimit
+	limit.register(flag.CommandLine)
+	var records recordRule
+	records.register(flag.CommandLine)
 	input := flag.String("input", "", "input file (defaults to stdin)")
 	output := flag.String("output", "", "output Arrow IPC file")
 	flush := flag.Int("flush-interval", 10000, "rows per record batch")
@@ -31,12 +46,23 @@ func main() {
 
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "carve - convert structured logs to Arrow format\n\n")
-		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s explain --pattern <regex>\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "       %s compile --pattern <regex> --output <plan>\n\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  %s --pattern '^(?P<ts>[^ ]+) (?P<level>\\w+) (?P<msg>.+)' --input app.log --output out.arrow\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s --pattern '^(?P<ts>[^ ]+) (?P<level>\\w+) (?P<msg>.+)' --schema\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --grok '^%%{IPORHOST:client} %%{NUMBER:bytes}$' --input app.log --output out.arrow\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --template '{ts} {level} {msg...}' --input app.log --output out.arrow\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --pattern '^(?P<status__int32>\\d+) (?P<latency__float64>\\S+)' --input app.log --output out.arrow\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --template '[{ts}] {msg...}' --timestamp 'ts=%%d/%%b/%%Y:%%H:%%M:%%S %%z' --time-unit ms --input app.log --output out.arrow\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s explain --pattern '^(?P<ts>[^ ]+) (?P<level>\\w+) (?P<msg>.+)'\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s compile --template '{ts} {level} {msg...}' --output app.plan\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --plan app.plan --input app.log --output out.arrow\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --template '{ts} {level} {msg...}' --record-start-prefix 20 --input app.log --output out.arrow\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --template '{ts} {level} {msg...}' --max-line-length 65536 --long-lines reject --reject-file long.log --input app.log --output out.arrow\n", os.Args[0])
 	}
 
 	flag.Parse()
@@ -45,8 +71,12 @@ func main() {
 		fmt.Println("carve", version)
 		return
 	}
-	if *pattern == "" {
-		fmt.Fprintf(os.Stderr, "Error: --pattern flag is required\n\n")
+	ok, err := src.resolve()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: --pattern, --grok, --template or --plan flag is required\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
@@ -56,22 +86,43 @@ func main() {
 		os.Exit(1)
 	}
 
-	re, err := regexp.Compile(*pattern)
+	s, err := src.scanner()
 	if err != nil {
-		log.Fatalf("failed to compile pattern: %v", err)
+		log.Fatal(err)
 	}
-
-	schema, err := carve.ExtractSchema(re)
-	if err != nil {
-		log.Fatalf("schema error: %v", err)
+	// Patterns keep their regexp semantics: a partial plan can reject
+	// lines they match, as it would for stock grok patterns that hold a
+	// space such as TIMESTAMP_ISO8601, so those are matched with the
+	// regexp.
+	if s.Mode() == carve.ModePartial {
+		if s, err = s.WithRegexp(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	s.WithOptions(carve.Options{ZeroCopy: true, Verify: true, TrimCR: true, Multiline: records.enabled()})
+	schema := s.Schema()
+	mem := memory.DefaultAllocator
+	writer := carve.NewWriter(schema, mem, *flush)
+	var truncation *truncationColumn
+	if limit.flagsTruncation() || records.limited() {
+		var err error
+		if schema, err = limit.extendSchema(schema); err != nil {
+			log.Fatal(err)
+		}
+		truncation = newTruncationColumn(schema, mem)
 	}
 	if *schemaOnly {
 		printSchema(schema)
 		return
 	}
 
-	mem := memory.DefaultAllocator
-	writer := carve.NewArrowWriter(schema, mem, *flush)
+	lineOpts, rejectFile, err := limit.options()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if rejectFile != nil {
+		defer rejectFile.Close()
+	}
 
 	var r *os.File
 	if *input != "" {
@@ -85,6 +136,16 @@ func main() {
 		r = os.Stdin
 	}
 
+	lr := carve.NewLineReader(r, 0).WithOptions(lineOpts)
+	next, truncated, unit := lr.Next, lr.Truncated, "line"
+	if records.enabled() {
+		rr, err := records.reader(lr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		next, truncated, unit = rr.Next, rr.Truncated, "record"
+	}
+
 	// Create output file and IPC writer once
 	outFile, err := os.Create(*output)
 	if err != nil {
@@ -98,71 +159,97 @@ func main() {
 	}
 	defer ipcWriter.Close()
 
-	scanner := bufio.NewScanner(r)
 	lineNum := 0
 	totalRows := 0
 	var batchStart time.Time
 	if *benchReport {
 		batchStart = time.Now()
 	}
-
-	for scanner.Scan() {
-		lineNum++
-		line := scanner.Text()
-
-		// Check max-rows limit
-		if *maxRows > 0 && totalRows >= *maxRows {
-			if *verbose {
-				log.Printf("reached max-rows limit of %d", *maxRows)
-			}
-			break
+	write := func(rec arrow.Record, what string) {
+		if truncation != nil {
+			rec = truncation.extend(rec)
 		}
+		defer rec.Release()
+		if err := ipcWriter.Write(rec); err != nil {
+			log.Fatalf("write error: %v", err)
+		}
+		if *benchReport {
+			log.Printf("[bench] %s: %d rows, %v", what, rec.NumRows(), time.Since(batchStart))
+			batchStart = time.Now()
+		}
+	}
+	warn := func(from, to int) {
+		for ; *verbose && from < to; from++ {
+			log.Printf("[warn] %s %d: does not match pattern", unit, lineNum+from+1)
+		}
+	}
 
-		vals := carve.ParseLine(line, re)
-		if vals == nil {
-			if *verbose {
-				log.Printf("[warn] line %d: does not match pattern", lineNum)
+read:
+	for {
+		lines, err := next()
+		if err == io.EOF {
+			break
+		} else if err == carve.ErrLineTooLong {
+			what := fmt.Sprintf("line %d is", lineNum+1)
+			if records.enabled() {
+				what = fmt.Sprintf("record %d has a line", lineNum+1)
 			}
-			continue
+			log.Fatalf("%s longer than --max-line-length %d; use --long-lines to truncate, skip or reject such lines", what, limit.max)
+		} else if err != nil {
+			log.Fatalf("read error: %v", err)
 		}
-		writer.Append(vals)
-		totalRows++
-
-		if writer.ShouldFlush() {
-			rec := writer.Flush()
-			if err := ipcWriter.Write(rec); err != nil {
-				rec.Release()
+		// The writer stops at the line that fills a batch, and no more
+		// lines are given it than --max-rows leaves, so a batch of lines
+		// can take several calls.
+		for base := 0; base < len(lines); {
+			if *maxRows > 0 && totalRows >= *maxRows {
+				if *verbose {
+					log.Printf("reached max-rows limit of %d", *maxRows)
+				}
+				break read
+			}
+			chunk := lines[base:]
+			if *maxRows > 0 && len(chunk) > *maxRows-totalRows {
+				chunk = chunk[:*maxRows-totalRows]
+			}
+			rec, err := writer.WriteLinesSIMD(chunk, s)
+			if err != nil {
 				log.Fatalf("write error: %v", err)
 			}
-			if *benchReport {
-				duration := time.Since(batchStart)
-				log.Printf("[bench] batch: %d rows, %v", rec.NumRows(), duration)
-				batchStart = time.Now()
+			rows, used := writer.Lines(), len(chunk)
+			if rec != nil {
+				used = rows[len(rows)-1] + 1
+			}
+			prev := 0
+			for _, i := range rows {
+				warn(prev, i)
+				prev = i + 1
+				if truncation != nil {
+					truncation.builder.Append(truncated(base + i))
+				}
+			}
+			warn(prev, used)
+			lineNum += used
+			totalRows += len(rows)
+			if rec != nil {
+				write(rec, "batch")
 			}
-			rec.Release()
+			base += used
 		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		log.Fatalf("scan error: %v", err)
-	}
-
 	// Flush remaining rows
-	if writer.Rows() > 0 {
-		rec := writer.Flush()
-		if err := ipcWriter.Write(rec); err != nil {
-			rec.Release()
-			log.Fatalf("write error: %v", err)
-		}
-		if *benchReport {
-			duration := time.Since(batchStart)
-			log.Printf("[bench] final batch: %d rows, %v", rec.NumRows(), duration)
-		}
-		rec.Release()
+	if rec, err := writer.Flush(); err != nil {
+		log.Fatalf("write error: %v", err)
+	} else if rec != nil {
+		write(rec, "final batch")
 	}
 
 	if *verbose {
-		fmt.Printf("processed %d lines, wrote %d rows to %s\n", lineNum, totalRows, *output)
+		fmt.Printf("processed %d %ss, wrote %d rows to %s\n", lineNum, unit, totalRows, *output)
+		if n := lr.LongLines(); n > 0 {
+			fmt.Printf("%d lines longer than %d bytes: %s\n", n, limit.max, lineOpts.Policy)
+		}
 	}
 }
 
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+
+	"carve/pkg/carve"
+)
+
+// recordRule holds the flags that join continuation lines, such as those
+// of a stack trace, to the record before them.
+type recordRule struct {
+	start    string
+	prefix   string
+	indented bool
+	maxLines int
+	maxBytes int
+}
+
+func (rule *recordRule) register(fs *flag.FlagSet) {
+	fs.StringVar(&rule.start, "record-start", "", "regex matching the first line of each record; other lines continue the record before them")
+	fs.StringVar(&rule.prefix, "record-start-prefix", "", "prefix of the first line of each record, used instead of --record-start")
+	fs.BoolVar(&rule.indented, "record-indented", false, "lines starting with a space or tab continue the record before them")
+	fs.IntVar(&rule.maxLines, "max-record-lines", 0, "most lines kept per record (0 = unlimited)")
+	fs.IntVar(&rule.maxBytes, "max-record-bytes", 0, "most bytes kept per record (0 = unlimited)")
+}
+
+// enabled reports whether lines are joined into records.
+func (rule *recordRule) enabled() bool {
+	return rule.start != "" || rule.prefix != "" || rule.indented
+}
+
+// limited reports whether records may lose continuation lines.
+func (rule *recordRule) limited() bool {
+	return rule.enabled() && (rule.maxLines > 0 || rule.maxBytes > 0)
+}
+
+// reader returns a RecordReader assembling the lines of lr.
+func (rule *recordRule) reader(lr *carve.LineReader) (*carve.RecordReader, error) {
+	rules := 0
+	for _, set := range []bool{rule.start != "", rule.prefix != "", rule.indented} {
+		if set {
+			rules++
+		}
+	}
+	if rules > 1 {
+		return nil, errors.New("only one of --record-start, --record-start-prefix and --record-indented may be given")
+	}
+	if rule.maxLines < 0 || rule.maxBytes < 0 {
+		return nil, errors.New("--max-record-lines and --max-record-bytes must not be negative")
+	}
+	opts := carve.RecordOptions{
+		StartPrefix: rule.prefix,
+		Indented:    rule.indented,
+		MaxLines:    rule.maxLines,
+		MaxBytes:    rule.maxBytes,
+	}
+	if rule.start != "" {
+		re, err := regexp.Compile(rule.start)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile --record-start: %w", err)
+		}
+		opts.StartPattern = re
+	}
+	return carve.NewRecordReader(lr, opts)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"carve/pkg/carve"
+)
+
+// source holds the flags that say what lines are scanned with. At most
+// one of pattern, grok, template and plan may be set.
+type source struct {
+	pattern      string
+	grok         string
+	grokPatterns string
+	template     string
+	plan         string
+	typeList     string
+	types        map[string]string // column types from --types
+	timestamps   timestampColumns
+}
+
+func (src *source) register(fs *flag.FlagSet) {
+	fs.StringVar(&src.pattern, "pattern", "", "regex pattern with named capture groups")
+	fs.StringVar(&src.grok, "grok", "", "grok pattern, used instead of --pattern")
+	fs.StringVar(&src.grokPatterns, "grok-patterns", "", "file or directory of additional grok patterns")
+	fs.StringVar(&src.template, "template", "", "field template such as '{ts} {level} {msg...}', used instead of --pattern")
+	fs.StringVar(&src.plan, "plan", "", "plan written by 'carve compile', used instead of --pattern")
+	fs.StringVar(&src.typeList, "types", "", "column types as field=type pairs, such as 'status=int32,latency=float64'")
+	src.timestamps.register(fs)
+}
+
+// resolve collects the column types and checks that a single source was
+// given. It reports false when there is none.
+func (src *source) resolve() (bool, error) {
+	types, err := parseTypes(src.typeList)
+	if err != nil {
+		return false, err
+	}
+	src.types = types
+
+	n := 0
+	for _, v := range []string{src.pattern, src.grok, src.template, src.plan} {
+		if v != "" {
+			n++
+		}
+	}
+	switch {
+	case n == 0:
+		return false, nil
+	case n > 1:
+		return false, errors.New("only one of --pattern, --grok, --template and --plan may be given")
+	}
+	return true, nil
+}
+
+// parseTypes parses the --types list.
+func parseTypes(list string) (map[string]string, error) {
+	types := map[string]string{}
+	if list == "" {
+		return types, nil
+	}
+	for _, pair := range strings.Split(list, ",") {
+		field, typ, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || field == "" || typ == "" {
+			return nil, fmt.Errorf("--types: %q is not a field=type pair", pair)
+		}
+		if _, dup := types[field]; dup {
+			return nil, fmt.Errorf("--types: field %q is given more than once", field)
+		}
+		types[field] = typ
+	}
+	return types, nil
+}
+
+// describe returns what kind of source is in use and its text.
+func (src *source) describe() (kind, text string) {
+	switch {
+	case src.grok != "":
+		return "Grok", src.grok
+	case src.template != "":
+		return "Template", src.template
+	case src.plan != "":
+		return "Plan", src.plan
+	default:
+		return "Pattern", src.pattern
+	}
+}
+
+// scanner builds a Scanner from the resolved source, with its column
+// types. Those from --types override grok type hints.
+func (src *source) scanner() (*carve.Scanner, error) {
+	s, err := src.build()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := s.WithTypes(src.types); err != nil {
+		return nil, err
+	}
+	return src.timestamps.applyTo(s)
+}
+
+func (src *source) build() (*carve.Scanner, error) {
+	switch {
+	case src.grok != "":
+		g := carve.NewGrok()
+		if src.grokPatterns != "" {
+			if err := g.AddPatternsFrom(src.grokPatterns); err != nil {
+				return nil, fmt.Errorf("grok error: %w", err)
+			}
+		}
+		s, err := g.New(src.grok)
+		if err != nil {
+			return nil, fmt.Errorf("grok error: %w", err)
+		}
+		return s, nil
+	case src.template != "":
+		s, err := carve.NewTemplate(src.template)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile template: %w", err)
+		}
+		return s, nil
+	case src.plan != "":
+		data, err := os.ReadFile(src.plan)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read plan: %w", err)
+		}
+		s, err := carve.LoadScanner(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load plan: %w", err)
+		}
+		return s, nil
+	default:
+		s, err := carve.New(src.pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile pattern: %w", err)
+		}
+		return s, nil
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+
+	"carve/pkg/carve"
+)
+
+// timeUnits are the values of --time-unit.
+var timeUnits = map[string]arrow.TimeUnit{
+	"s":  arrow.Second,
+	"ms": arrow.Millisecond,
+	"us": arrow.Microsecond,
+	"ns": arrow.Nanosecond,
+}
+
+// timestampColumns holds the flags that make columns timestamps.
+type timestampColumns struct {
+	fields  []string
+	layouts map[string]string
+	unit    string
+	zone    string
+}
+
+func (tc *timestampColumns) register(fs *flag.FlagSet) {
+	fs.Func("timestamp", "timestamp column as field=layout, the layout being iso8601, epoch_s, epoch_ms, epoch_us, epoch_ns, a strftime or a Go layout; may be repeated", tc.add)
+	fs.StringVar(&tc.unit, "time-unit", "us", "unit of --timestamp columns: s, ms, us or ns")
+	fs.StringVar(&tc.zone, "time-zone", "UTC", "time zone of --timestamp columns, and of their values without an offset")
+}
+
+func (tc *timestampColumns) add(v string) error {
+	field, layout, ok := strings.Cut(v, "=")
+	if !ok || field == "" {
+		return fmt.Errorf("%q is not a field=layout pair", v)
+	}
+	if _, dup := tc.layouts[field]; dup {
+		return fmt.Errorf("field %q is given more than once", field)
+	}
+	if tc.layouts == nil {
+		tc.layouts = map[string]string{}
+	}
+	tc.fields = append(tc.fields, field)
+	tc.layouts[field] = layout
+	return nil
+}
+
+// options returns the options of each --timestamp column, in order.
+func (tc *timestampColumns) options() ([]carve.TimestampOptions, error) {
+	unit, ok := timeUnits[tc.unit]
+	if !ok {
+		return nil, fmt.Errorf("unknown --time-unit %q", tc.unit)
+	}
+	opts := make([]carve.TimestampOptions, len(tc.fields))
+	for i, field := range tc.fields {
+		opts[i] = carve.TimestampOptions{Layout: tc.layouts[field], Unit: unit, TimeZone: tc.zone}
+	}
+	return opts, nil
+}
+
+// applyTo makes the --timestamp columns of s timestamps.
+func (tc *timestampColumns) applyTo(s *carve.Scanner) (*carve.Scanner, error) {
+	opts, err := tc.options()
+	if err != nil {
+		return nil, err
+	}
+	for i, field := range tc.fields {
+		if _, err := s.WithTimestamp(field, opts[i]); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
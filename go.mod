@@ -2,7 +2,10 @@ module carve
 
 go 1.24.3
 
-require github.com/apache/arrow-go/v18 v18.3.1
+require (
+	github.com/apache/arrow-go/v18 v18.3.1
+	golang.org/x/sys v0.33.0
+)
 
 require (
 	github.com/goccy/go-json v0.10.5 // indirect
@@ -14,7 +17,6 @@ require (
 	golang.org/x/exp v0.0.0-20250620022241-b7579e27df2b // indirect
 	golang.org/x/mod v0.25.0 // indirect
 	golang.org/x/sync v0.15.0 // indirect
-	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/tools v0.34.0 // indirect
 	golang.org/x/xerrors v0.0.0-20240903120638-7835f813f4da // indirect
 )
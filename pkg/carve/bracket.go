@@ -0,0 +1,50 @@
+package carve
+
+import "regexp/syntax"
+
+// ============================================================
+// Bracketed fields
+// ============================================================
+
+// brackets lists the open/close pairs a field may be enclosed in.
+var brackets = [...]string{"[]", "()", "{}", "<>"}
+
+// bracketPair returns the open/close pair a field is enclosed in when
+// the literal before it ends with an opening bracket and the literal
+// after it starts with the matching close, or nil. reach is what the
+// field's content can match; it must exclude the close, so that the
+// field ends exactly at the first one.
+func bracketPair(before, after []byte, reach byteSet) []byte {
+	if len(before) == 0 || len(after) == 0 {
+		return nil
+	}
+	for _, b := range brackets {
+		if before[len(before)-1] == b[0] && after[0] == b[1] && !reach.has(b[1]) {
+			return []byte(b)
+		}
+	}
+	return nil
+}
+
+// pairBrackets turns fields of p enclosed in brackets into pair
+// instructions. The opening bracket stays in the literal before the
+// field, which consumes it; the closing one moves from the delimiter into
+// the instruction. The last field is left alone: its trailing literal
+// already pins it down.
+func pairBrackets(p *scanProg, nodes []*syntax.Regexp) {
+	for i := 0; i < len(p.fields)-1; i++ {
+		f := &p.fields[i]
+		if f.lead != nil || i > 0 && p.fields[i-1].trail != nil {
+			// the brackets are not next to the field
+			continue
+		}
+		before := p.prefix
+		if i > 0 {
+			before = p.fields[i-1].delim
+		}
+		if pair := bracketPair(before, f.delim, reachBytes(nodes[i])); pair != nil {
+			f.pair = pair
+			f.delim = f.delim[1:]
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package carve
+
+import (
+	"regexp/syntax"
+	"unicode"
+	"unicode/utf8"
+)
+
+// byteSet is a 256-bit membership set over byte values. It is the scan
+// VM's representation of a regex character class.
+type byteSet [4]uint64
+
+func (s *byteSet) add(b byte) { s[b>>6] |= 1 << (b & 63) }
+
+func (s *byteSet) addRange(lo, hi byte) {
+	for c := int(lo); c <= int(hi); c++ {
+		s.add(byte(c))
+	}
+}
+
+func (s *byteSet) remove(b byte) { s[b>>6] &^= 1 << (b & 63) }
+
+func (s *byteSet) has(b byte) bool { return s[b>>6]&(1<<(b&63)) != 0 }
+
+// span returns the length of the run of member bytes at the start of b,
+// stopping after limit bytes when limit >= 0.
+func (s *byteSet) span(b []byte, limit int) int {
+	if limit >= 0 && limit < len(b) {
+		b = b[:limit]
+	}
+	for i, c := range b {
+		if !s.has(c) {
+			return i
+		}
+	}
+	return len(b)
+}
+
+// classSet converts a single-character node into a byteSet. Rune ranges
+// above ASCII are only representable when the class accepts every
+// non-ASCII rune (as negated classes such as [^ ] do), in which case all
+// bytes of a multi-byte encoding are members.
+func classSet(n *syntax.Regexp) (byteSet, bool) {
+	var set byteSet
+	switch n.Op {
+	case syntax.OpAnyChar:
+		set.addRange(0, 0xff)
+	case syntax.OpAnyCharNotNL:
+		set.addRange(0, 0xff)
+		set.remove('\n')
+	case syntax.OpLiteral:
+		if len(n.Rune) != 1 || n.Rune[0] >= utf8.RuneSelf {
+			return set, false
+		}
+		r := n.Rune[0]
+		set.add(byte(r))
+		if n.Flags&syntax.FoldCase != 0 {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				if f >= utf8.RuneSelf {
+					return set, false
+				}
+				set.add(byte(f))
+			}
+		}
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(n.Rune); i += 2 {
+			lo, hi := n.Rune[i], n.Rune[i+1]
+			if lo < utf8.RuneSelf {
+				set.addRange(byte(lo), byte(min(hi, utf8.RuneSelf-1)))
+			}
+			if hi < utf8.RuneSelf {
+				continue
+			}
+			if lo > utf8.RuneSelf || hi < unicode.MaxRune {
+				return set, false
+			}
+			set.addRange(utf8.RuneSelf, 0xff)
+		}
+	default:
+		return set, false
+	}
+	return set, true
+}
+
+// runClass recognizes a repeated single-character expression such as
+// \d+, [^ ]* or \w{2,4} and returns its class with the repeat bounds in
+// bytes (max < 0 means unbounded).
+func runClass(n *syntax.Regexp) (set byteSet, lo, hi int, ok bool) {
+	lo, hi = 1, 1
+	switch n.Op {
+	case syntax.OpPlus:
+		lo, hi = 1, -1
+	case syntax.OpStar:
+		lo, hi = 0, -1
+	case syntax.OpQuest:
+		lo, hi = 0, 1
+	case syntax.OpRepeat:
+		lo, hi = n.Min, n.Max
+	default:
+		set, ok = classSet(n)
+		return set, lo, hi, ok
+	}
+	set, ok = classSet(n.Sub[0])
+	// A bounded count is in runes; it only equals a byte count when the
+	// class is pure ASCII.
+	if ok && hi >= 0 && !set.ascii() {
+		ok = false
+	}
+	return set, lo, hi, ok
+}
+
+func (s *byteSet) ascii() bool { return s[2] == 0 && s[3] == 0 }
+
+// reachBytes over-approximates the set of bytes any match of n can
+// contain. Non-ASCII runes contribute every byte >= 0x80.
+func reachBytes(n *syntax.Regexp) byteSet {
+	var set byteSet
+	switch n.Op {
+	case syntax.OpLiteral:
+		for _, r := range n.Rune {
+			if r >= utf8.RuneSelf {
+				set.addRange(utf8.RuneSelf, 0xff)
+				continue
+			}
+			set.add(byte(r))
+			if n.Flags&syntax.FoldCase != 0 {
+				for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+					if f >= utf8.RuneSelf {
+						set.addRange(utf8.RuneSelf, 0xff)
+					} else {
+						set.add(byte(f))
+					}
+				}
+			}
+		}
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(n.Rune); i += 2 {
+			lo, hi := n.Rune[i], n.Rune[i+1]
+			if lo < utf8.RuneSelf {
+				set.addRange(byte(lo), byte(min(hi, utf8.RuneSelf-1)))
+			}
+			if hi >= utf8.RuneSelf {
+				set.addRange(utf8.RuneSelf, 0xff)
+			}
+		}
+	case syntax.OpAnyChar:
+		set.addRange(0, 0xff)
+	case syntax.OpAnyCharNotNL:
+		set.addRange(0, 0xff)
+		set.remove('\n')
+	default:
+		for _, sub := range n.Sub {
+			set.union(reachBytes(sub))
+		}
+	}
+	return set
+}
+
+// reachRune reports whether a match of n can contain the rune r. Unlike
+// reachBytes it tells non-ASCII runes apart, so a field such as [^│]+ is
+// known not to contain a │ delimiter.
+func reachRune(n *syntax.Regexp, r rune) bool {
+	switch n.Op {
+	case syntax.OpLiteral:
+		for _, c := range n.Rune {
+			if c == r || n.Flags&syntax.FoldCase != 0 && sameFold(c, r) {
+				return true
+			}
+		}
+		return false
+	case syntax.OpCharClass:
+		for i := 0; i+1 < len(n.Rune); i += 2 {
+			if n.Rune[i] <= r && r <= n.Rune[i+1] {
+				return true
+			}
+		}
+		return false
+	case syntax.OpAnyChar:
+		return true
+	case syntax.OpAnyCharNotNL:
+		return r != '\n'
+	}
+	for _, sub := range n.Sub {
+		if reachRune(sub, r) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameFold(a, b rune) bool {
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
+		}
+	}
+	return false
+}
+
+// mayContain reports whether a match of n can contain the first
+// character of delim.
+func mayContain(n *syntax.Regexp, delim []byte) bool {
+	if delim[0] < utf8.RuneSelf {
+		reach := reachBytes(n)
+		return reach.has(delim[0])
+	}
+	r, _ := utf8.DecodeRune(delim)
+	return reachRune(n, r)
+}
+
+func (s *byteSet) union(o byteSet) {
+	for i := range s {
+		s[i] |= o[i]
+	}
+}
+
+func (s *byteSet) intersects(o byteSet) bool {
+	for i := range s {
+		if s[i]&o[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
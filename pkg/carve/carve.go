@@ -3,8 +3,8 @@ package carve
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"regexp"
-	"regexp/syntax"
 
 	"github.com/apache/arrow-go/v18/arrow"
 	"github.com/apache/arrow-go/v18/arrow/array"
@@ -16,7 +16,8 @@ import (
 // ============================================================
 
 // New creates a high-performance scanner from a regex pattern.
-// Only named capture groups are supported.
+// Only named capture groups are supported. Patterns the scan plan cannot
+// represent fall back to regexp matching; Mode reports which engine is used.
 func New(pattern string) (*Scanner, error) {
 	re, err := regexp.Compile(pattern)
 	if err != nil {
@@ -41,12 +42,20 @@ func New(pattern string) (*Scanner, error) {
 		return nil, err
 	}
 
-	return &Scanner{
-		schema:  schema,
-		fields:  plan,
-		scratch: make([][]byte, len(plan)),
-		opts:    Options{ZeroCopy: true},
-	}, nil
+	s := &Scanner{
+		schema:   schema,
+		scanProg: plan.scanProg,
+		numCols:  numFields,
+		sparse:   plan.sparse,
+		re:       re,
+		capCol:   plan.capCol,
+		mode:     plan.mode,
+		warnings: plan.warnings,
+		scratch:  make([][]byte, numFields),
+		opts:     Options{ZeroCopy: true},
+	}
+	s.compactPlan()
+	return s, nil
 }
 
 // NewExtractor is an alias for New for API compatibility.
@@ -55,50 +64,604 @@ func NewExtractor(pattern string) (*Scanner, error) {
 }
 
 type Scanner struct {
-	schema  *arrow.Schema
-	fields  []fieldVM
-	scratch [][]byte
-	opts    Options
+	schema *arrow.Schema
+	scanProg
+	split    []splitField // the plan as plain splits, if it is no more; see scanSplit
+	numCols  int
+	sparse   bool
+	re       *regexp.Regexp
+	capCol   []int
+	mode     Mode
+	warnings []string
+	scratch  [][]byte
+	opts     Options
+	reason   RejectReason
+	unesc    []byte     // unescaped quoted fields of the current line
+	retain   bool       // unesc and joined are kept across lines; see startBatch
+	joined   []byte     // the last field of the current record, if copied
+	endCol   int        // column that ended the line, after a branch or in ModeRegexp; see recordCol
+	spans    [][2]int32 // where each field lies in the line, while scanning in place; see note
+	base     *unprojected
+	index    []uint64 // structural index of the last ScanBatch buffer
+	noIndex  bool     // ScanBatch uses the per-line VM; for comparing the two
 }
 
 type Options struct {
 	ZeroCopy bool
+	// Verify makes Scan check the line against the pattern's literals,
+	// anchors and required fields, returning false when it does not
+	// conform. Reason reports why the last line was rejected.
+	Verify bool
+	// Unescape resolves the escapes of quoted fields, and strips the
+	// quotes of fields that include them. Fields without escapes are
+	// still returned as slices of the line.
+	Unescape bool
+	// TrimCR drops a trailing \r from each line before scanning, for
+	// CRLF input.
+	TrimCR bool
+	// Multiline scans records of several lines, such as RecordReader
+	// assembles: only the first line is scanned, and checked, and the
+	// rest of the record, from the \n on, is appended to the field that
+	// ends it. ScanOffsets and ScanBatch ignore it.
+	Multiline bool
 }
 
-// Scan writes captured fields into `out`. Returns true if at least one field was extracted.
+// RejectReason explains why a verifying Scan returned false.
+type RejectReason uint8
+
+const (
+	RejectNone        RejectReason = iota
+	RejectShortOutput              // out has fewer slots than the scanner has fields
+	RejectPrefix                   // the line does not start with the leading literal
+	RejectDelimiter                // a separator between two fields is missing
+	RejectShortField               // a field is shorter than the pattern requires
+	RejectSuffix                   // the trailing literal is missing
+	RejectClass                    // a field contains bytes outside its character class
+	RejectTrailing                 // bytes remain after an end-anchored pattern
+	RejectContent                  // a field does not match its sub-expression
+	RejectNoMatch                  // the regexp fallback found no match
+)
+
+func (r RejectReason) String() string {
+	switch r {
+	case RejectNone:
+		return "none"
+	case RejectShortOutput:
+		return "short output"
+	case RejectPrefix:
+		return "missing prefix"
+	case RejectDelimiter:
+		return "missing delimiter"
+	case RejectShortField:
+		return "short field"
+	case RejectSuffix:
+		return "missing suffix"
+	case RejectClass:
+		return "class mismatch"
+	case RejectTrailing:
+		return "trailing data"
+	case RejectContent:
+		return "content mismatch"
+	case RejectNoMatch:
+		return "no match"
+	default:
+		return "unknown"
+	}
+}
+
+// Scan writes captured fields into `out`. With Options.Verify, lines that
+// do not conform are rejected. Without it Scan returns false only when
+// out is shorter than the schema, when no alternative of a branch fits
+// the line (alternatives are always verified), or in ModeRegexp when the
+// pattern does not match; any other line is accepted as it splits.
+// Reason says which. Fields of optional groups or alternatives that did
+// not match are nil.
 // The returned slices are valid only until the next Scan call when ZeroCopy=true.
 func (s *Scanner) Scan(line []byte, out [][]byte) bool {
-	if len(out) < len(s.fields) {
+	if len(out) < s.numCols {
+		s.reason = RejectShortOutput
 		return false
 	}
+	if s.opts.TrimCR && len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	if s.opts.Multiline {
+		if n := bytes.IndexByte(line, '\n'); n >= 0 {
+			return s.scanRecord(line, n, out)
+		}
+	}
+	if s.split != nil && !s.opts.Verify {
+		return s.scanSplit(line, out)
+	}
+	if s.mode == ModeRegexp {
+		return s.scanRegexp(line, out)
+	}
+	if s.sparse {
+		clear(out[:s.numCols])
+	}
+	if s.opts.Unescape && !s.retain {
+		// unescaping never grows a field, so this is all one line needs
+		if cap(s.unesc) < len(line) {
+			s.unesc = make([]byte, 0, len(line))
+		}
+		s.unesc = s.unesc[:0]
+	}
+	if s.opts.Verify {
+		return s.scanVerify(&s.scanProg, line, 0, out)
+	}
+	return s.scanFast(&s.scanProg, line, 0, out)
+}
 
-	pos := 0
+// scanFast runs p without checking the line against it. Branches are the
+// exception: choosing an alternative requires verifying it.
+func (s *Scanner) scanFast(p *scanProg, line []byte, pos int, out [][]byte) bool {
 	n := len(line)
+	if len(p.prefix) > 0 && bytes.HasPrefix(line[pos:], p.prefix) {
+		pos += len(p.prefix)
+	}
+
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.alts != nil {
+			return s.branch(f, line, pos, out)
+		}
 
-	for i, f := range s.fields {
 		if f.isLast {
-			if pos < n {
-				out[i] = slice(line, pos, n, s.opts.ZeroCopy)
-			} else {
-				out[i] = nil
+			end := n
+			if e := p.lastEnd(f, line[pos:]); e >= 0 {
+				end = pos + e
+			}
+			switch {
+			case f.col < 0:
+			case pos >= n:
+				out[f.col] = nil
+			case f.quotes != nil:
+				out[f.col] = s.quoted(f, line, pos, end)
+				s.note(f.col, pos, end)
+			default:
+				out[f.col] = slice(line, pos, end, s.opts.ZeroCopy)
+				s.note(f.col, pos, end)
 			}
 			return true
 		}
 
+		if f.byClass {
+			end := pos + f.class.span(line[pos:], f.max)
+			if f.col >= 0 {
+				out[f.col] = slice(line, pos, end, s.opts.ZeroCopy)
+				s.note(f.col, pos, end)
+			}
+			pos = end
+			continue
+		}
+
+		if f.pair != nil {
+			end := bytes.IndexByte(line[pos:], f.pair[1])
+			if end < 0 {
+				s.absent(f, out)
+				pos = n
+				continue
+			}
+			if f.col >= 0 {
+				out[f.col] = slice(line, pos, pos+end, s.opts.ZeroCopy)
+				s.note(f.col, pos, pos+end)
+			}
+			pos = f.skipSep(line, pos+end+1)
+			continue
+		}
+
+		if f.quotes != nil {
+			if end := f.quoteEnd(line[pos:]); end >= 0 {
+				if f.col >= 0 {
+					out[f.col] = s.quoted(f, line, pos, pos+end)
+					s.note(f.col, pos, pos+end)
+				}
+				pos = f.skipSep(line, pos+end)
+				continue
+			}
+		}
+
+		idx := f.sepIndex(line[pos:])
+		if idx < 0 {
+			s.absent(f, out)
+			pos = n
+			continue
+		}
+		if f.col >= 0 {
+			out[f.col] = slice(line, pos, pos+idx, s.opts.ZeroCopy)
+			s.note(f.col, pos, pos+idx)
+		}
+		if f.lead != nil || f.trail != nil {
+			pos = f.skipSep(line, pos+idx)
+		} else {
+			pos += idx + len(f.delim)
+		}
+	}
+	return true
+}
+
+// splitField is a field of a plan that only splits the line at
+// single-byte delimiters, which is most of them.
+type splitField struct {
+	delim byte // unused for the last field
+	col   int
+}
+
+// compactPlan sets s.split when every field but the last ends at a
+// single-byte delimiter and the last takes the rest of the line, so that
+// Scan can run the plan without the instructions fieldVM has for
+// everything else.
+func (s *Scanner) compactPlan() {
+	s.split = nil
+	if s.mode == ModeRegexp || s.sparse || len(s.suffix) > 0 || len(s.fields) == 0 {
+		return
+	}
+	split := make([]splitField, len(s.fields))
+	for i := range s.fields {
+		f := &s.fields[i]
+		switch {
+		case f.alts != nil, f.byClass, f.quotes != nil, f.pair != nil, f.lead != nil, f.trail != nil:
+			return
+		case f.isLast != (i == len(s.fields)-1), !f.isLast && len(f.delim) != 1:
+			return
+		}
+		split[i].col = f.col
+		if !f.isLast {
+			split[i].delim = f.delim[0]
+		}
+	}
+	s.split = split
+}
+
+// scanSplit is scanFast for a plan compactPlan reduced to s.split.
+func (s *Scanner) scanSplit(line []byte, out [][]byte) bool {
+	pos, n := 0, len(line)
+	if len(s.prefix) > 0 && bytes.HasPrefix(line, s.prefix) {
+		pos = len(s.prefix)
+	}
+	last := len(s.split) - 1
+	for _, f := range s.split[:last] {
 		idx := bytes.IndexByte(line[pos:], f.delim)
 		if idx < 0 {
-			out[i] = nil
+			if f.col >= 0 {
+				out[f.col] = nil
+			}
 			pos = n
+			continue
+		}
+		if f.col >= 0 {
+			out[f.col] = slice(line, pos, pos+idx, s.opts.ZeroCopy)
+			s.note(f.col, pos, pos+idx)
+		}
+		pos += idx + 1
+	}
+	if f := s.split[last]; f.col >= 0 {
+		if pos < n {
+			out[f.col] = slice(line, pos, n, s.opts.ZeroCopy)
+			s.note(f.col, pos, n)
 		} else {
-			out[i] = slice(line, pos, pos+idx, s.opts.ZeroCopy)
-			pos += idx + 1
+			out[f.col] = nil
+		}
+	}
+	return true
+}
+
+// absent clears the column of a field the fast path found no end for.
+func (s *Scanner) absent(f *fieldVM, out [][]byte) {
+	if f.col >= 0 {
+		out[f.col] = nil
+	}
+}
+
+// scanVerify is Scan with every check the plan can express enabled. It is
+// kept apart from the fast path so unverified scans pay nothing for it.
+func (s *Scanner) scanVerify(p *scanProg, line []byte, pos int, out [][]byte) bool {
+	n := len(line)
+	if len(p.prefix) > 0 {
+		if !bytes.HasPrefix(line[pos:], p.prefix) {
+			return s.reject(RejectPrefix)
+		}
+		pos += len(p.prefix)
+	}
+
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.alts != nil {
+			return s.branch(f, line, pos, out)
+		}
+
+		if f.isLast {
+			rest := line[pos:]
+			body := rest
+			if len(p.suffix) > 0 {
+				// An anchored suffix must end the line; a field that
+				// cannot contain it then fails the content check if it
+				// does.
+				end := -1
+				switch {
+				case !p.anchorEnd:
+					end = p.lastEnd(f, rest)
+				case bytes.HasSuffix(rest, p.suffix):
+					end = len(rest) - len(p.suffix)
+				}
+				if end < 0 {
+					return s.reject(RejectSuffix)
+				}
+				body = rest[:end]
+			} else if f.hasClass && !p.anchorEnd {
+				// Nothing pins the end, so the field is its class run and
+				// whatever follows is ignored, as it would be by regexp.
+				body = rest[:f.class.span(rest, f.max)]
+			}
+			if len(body) < f.min {
+				return s.reject(RejectShortField)
+			}
+			// An unanchored suffix the field may contain leaves its end
+			// unknown, so the content is only checked otherwise.
+			if len(p.suffix) == 0 || p.anchorEnd || f.suffixFirst {
+				if f.hasClass && !f.conforms(body) {
+					return s.reject(RejectClass)
+				}
+				if f.re != nil && !f.re.Match(body) {
+					return s.reject(RejectContent)
+				}
+			}
+			// Unlike the fast path, an empty last field is reported as
+			// empty rather than absent.
+			switch {
+			case f.col < 0:
+			case f.quotes != nil:
+				out[f.col] = s.quoted(f, line, pos, pos+len(body))
+				s.note(f.col, pos, pos+len(body))
+			default:
+				out[f.col] = slice(line, pos, pos+len(body), s.opts.ZeroCopy)
+				s.note(f.col, pos, pos+len(body))
+			}
+			s.reason = RejectNone
+			return true
+		}
+
+		if f.byClass {
+			end := pos + f.class.span(line[pos:], f.max)
+			if end-pos < f.min {
+				return s.reject(RejectShortField)
+			}
+			if f.col >= 0 {
+				out[f.col] = slice(line, pos, end, s.opts.ZeroCopy)
+				s.note(f.col, pos, end)
+			}
+			pos = end
+			continue
+		}
+
+		if f.pair != nil {
+			end := bytes.IndexByte(line[pos:], f.pair[1])
+			next := -1
+			if end >= 0 {
+				next = f.sepEnd(line, pos+end+1)
+			}
+			switch {
+			case next < 0:
+				return s.reject(RejectDelimiter)
+			case end < f.min:
+				return s.reject(RejectShortField)
+			case f.hasClass && !f.conforms(line[pos:pos+end]):
+				return s.reject(RejectClass)
+			case f.re != nil && !f.re.Match(line[pos:pos+end]):
+				return s.reject(RejectContent)
+			}
+			if f.col >= 0 {
+				out[f.col] = slice(line, pos, pos+end, s.opts.ZeroCopy)
+				s.note(f.col, pos, pos+end)
+			}
+			pos = next
+			continue
+		}
+
+		if f.quotes != nil {
+			end := f.quoteEnd(line[pos:])
+			next := -1
+			if end >= 0 {
+				next = f.sepEnd(line, pos+end)
+			}
+			switch {
+			case end < 0 && f.enclosed:
+				return s.reject(RejectContent)
+			case next < 0:
+				return s.reject(RejectDelimiter)
+			case end < f.min:
+				return s.reject(RejectShortField)
+			case f.re != nil && !f.re.Match(line[pos:pos+end]):
+				return s.reject(RejectContent)
+			}
+			if f.col >= 0 {
+				out[f.col] = s.quoted(f, line, pos, pos+end)
+				s.note(f.col, pos, pos+end)
+			}
+			pos = next
+			continue
+		}
+
+		idx := f.sepIndex(line[pos:])
+		if idx < 0 {
+			return s.reject(RejectDelimiter)
+		}
+		next := pos + idx + len(f.delim)
+		if f.lead != nil || f.trail != nil {
+			if next = f.sepEnd(line, pos+idx); next < 0 {
+				return s.reject(RejectDelimiter)
+			}
+		}
+		if idx < f.min {
+			return s.reject(RejectShortField)
 		}
+		if f.hasClass && !f.conforms(line[pos:pos+idx]) {
+			return s.reject(RejectClass)
+		}
+		if f.re != nil && !f.re.Match(line[pos:pos+idx]) {
+			return s.reject(RejectContent)
+		}
+		if f.col >= 0 {
+			out[f.col] = slice(line, pos, pos+idx, s.opts.ZeroCopy)
+			s.note(f.col, pos, pos+idx)
+		}
+		pos = next
 	}
+
+	// A program without fields is a run of literals.
+	if p.anchorEnd && pos != n {
+		return s.reject(RejectTrailing)
+	}
+	s.reason = RejectNone
 	return true
 }
 
+// startBatch makes the values Scan builds itself, rather than slicing the
+// line, last until endBatch instead of the next Scan, for callers that
+// hold on to the rows of a batch. These are the unescaped fields with
+// Unescape and the joined records with Multiline, which then grow one
+// buffer each: one that reallocates leaves the earlier values in the old
+// one.
+func (s *Scanner) startBatch() {
+	s.unesc, s.joined = s.unesc[:0], s.joined[:0]
+	s.retain = true
+}
+
+// endBatch returns Scan to reusing its buffers on every line.
+func (s *Scanner) endBatch() { s.retain = false }
+
+// branch tries each alternative of f in order and keeps the first one
+// that verifies. Columns written by a failed attempt are cleared so they
+// cannot leak into the result, and its unescaped content is dropped.
+func (s *Scanner) branch(f *fieldVM, line []byte, pos int, out [][]byte) bool {
+	mark := len(s.unesc)
+	for i := range f.alts {
+		if s.scanVerify(&f.alts[i], line, pos, out) {
+			// a nested branch has already set endCol
+			if alt := &f.alts[i]; len(alt.fields) == 0 || alt.fields[len(alt.fields)-1].alts == nil {
+				s.endCol = alt.lastCol()
+			}
+			return true
+		}
+		for _, c := range f.cols {
+			out[c] = nil
+		}
+		s.unesc = s.unesc[:mark]
+	}
+	return false
+}
+
+// scanRegexp is the engine for patterns the scan plan cannot represent.
+// It still writes into the caller's out, so callers need not care which
+// engine is in use.
+func (s *Scanner) scanRegexp(line []byte, out [][]byte) bool {
+	m := s.re.FindSubmatchIndex(line)
+	if m == nil {
+		clear(out[:s.numCols])
+		return s.reject(RejectNoMatch)
+	}
+	names := s.re.SubexpNames()
+	s.endCol = -1
+	last := -1
+	for c, col := range s.capCol {
+		start, end := m[2*c], m[2*c+1]
+		// the named group ending last, the outermost of those that do,
+		// even if projected away
+		if start >= 0 && names[c] != "" && end > last {
+			s.endCol, last = col, end
+		}
+		switch {
+		case col < 0:
+		case start >= 0:
+			out[col] = slice(line, start, end, s.opts.ZeroCopy)
+			s.note(col, start, end)
+		default:
+			out[col] = nil
+		}
+	}
+	s.reason = RejectNone
+	return true
+}
+
+// note records that column c was set to line[start:end] when the line
+// is scanned in place for ScanOffsets or ScanBatch, which report those
+// positions rather than the values.
+func (s *Scanner) note(c, start, end int) {
+	if c < len(s.spans) {
+		s.spans[c] = [2]int32{int32(start), int32(end)}
+	}
+}
+
+func (s *Scanner) reject(r RejectReason) bool {
+	s.reason = r
+	return false
+}
+
+// Reason reports why the last Scan returned false, or RejectNone if it
+// succeeded.
+func (s *Scanner) Reason() RejectReason { return s.reason }
+
+// lastEnd returns the length of the last field at the start of rest, or
+// -1 if the trailing literal is missing. A field that cannot contain the
+// literal ends at its first occurrence; otherwise an anchored literal
+// must end the line and an unanchored one is taken at its last
+// occurrence, as a greedy match would.
+func (p *scanProg) lastEnd(f *fieldVM, rest []byte) int {
+	switch {
+	case len(p.suffix) == 0:
+		return len(rest)
+	case f.suffixFirst:
+		return bytes.Index(rest, p.suffix)
+	case p.anchorEnd:
+		if !bytes.HasSuffix(rest, p.suffix) {
+			return -1
+		}
+		return len(rest) - len(p.suffix)
+	default:
+		return bytes.LastIndex(rest, p.suffix)
+	}
+}
+
+// skipDelim returns the position after the delimiter that should follow
+// a field ending at pos. If it does not follow, scanning resumes at its
+// next occurrence like it would for any other field.
+func skipDelim(line []byte, pos int, delim []byte) int {
+	idx := indexDelim(line[pos:], delim)
+	if idx < 0 {
+		return len(line)
+	}
+	return pos + idx + len(delim)
+}
+
+// indexDelim finds a field separator, taking the IndexByte fast path for
+// the common single-byte case.
+func indexDelim(b, delim []byte) int {
+	if len(delim) == 1 {
+		return bytes.IndexByte(b, delim[0])
+	}
+	return bytes.Index(b, delim)
+}
+
 func (s *Scanner) Schema() *arrow.Schema { return s.schema }
 
+// Mode reports whether the scanner runs an exact scan plan, an
+// approximate one, or the regexp fallback.
+func (s *Scanner) Mode() Mode { return s.mode }
+
+// WithRegexp makes s match lines with the regexp of its pattern, as in
+// ModeRegexp, instead of its scan plan. Verified scans of a ModePartial
+// plan can reject lines the pattern matches; this trades their speed
+// for the pattern's exact semantics. Scanners of templates, which have
+// no regexp, return an error.
+func (s *Scanner) WithRegexp() (*Scanner, error) {
+	if s.re == nil {
+		return nil, errors.New("carve: scanner has no regexp to fall back to")
+	}
+	s.mode = ModeRegexp
+	s.split = nil
+	return s, nil
+}
+
 // Scanner returns a new scanner with the given options (for chaining).
 func (s *Scanner) Scanner(opts Options) *Scanner {
 	s.opts = opts
@@ -118,14 +681,18 @@ type Writer struct {
 	schema      *arrow.Schema
 	mem         memory.Allocator
 	maxRows     int
-	builders    []*array.BinaryBuilder
+	builders    []array.Builder
 	scratch     [][]byte
 	tempColVals [][][]byte
 	tempValids  [][]bool
 	arrScratch  []arrow.Array
 	rows        int
+	lines       []int // of the last WriteLinesSIMD call; see Lines
 }
 
+// NewWriter returns a Writer building batches of up to maxRows rows of
+// schema. The values of columns typed by Scanner.WithTypes or the
+// pattern's annotations are parsed; those that do not parse are null.
 func NewWriter(schema *arrow.Schema, mem memory.Allocator, maxRows int) *Writer {
 	if mem == nil {
 		mem = memory.DefaultAllocator
@@ -135,9 +702,9 @@ func NewWriter(schema *arrow.Schema, mem memory.Allocator, maxRows int) *Writer
 	}
 
 	numCols := len(schema.Fields())
-	builders := make([]*array.BinaryBuilder, numCols)
-	for i := range builders {
-		builders[i] = array.NewBinaryBuilder(mem, arrow.BinaryTypes.Binary)
+	builders := make([]array.Builder, numCols)
+	for i, f := range schema.Fields() {
+		builders[i] = newColumnBuilder(mem, f)
 	}
 
 	tempColVals := make([][][]byte, numCols)
@@ -159,6 +726,10 @@ func NewWriter(schema *arrow.Schema, mem memory.Allocator, maxRows int) *Writer
 	}
 }
 
+// WriteLinesSIMD scans lines with s and appends a row for each line it
+// accepts. It returns the batch as soon as it holds maxRows rows, leaving
+// the lines after the one that filled it unscanned; Lines says which
+// lines were written, and so where to resume.
 func (w *Writer) WriteLinesSIMD(lines [][]byte, s *Scanner) (arrow.Record, error) {
 	builders := w.builders
 	scratch := w.scratch
@@ -170,11 +741,16 @@ func (w *Writer) WriteLinesSIMD(lines [][]byte, s *Scanner) (arrow.Record, error
 		w.tempColVals[i] = w.tempColVals[i][:0]
 		w.tempValids[i] = w.tempValids[i][:0]
 	}
+	w.lines = w.lines[:0]
+	// the rows are only copied into the builders once the loop ends
+	s.startBatch()
+	defer s.endBatch()
 
-	for _, line := range lines {
+	for n, line := range lines {
 		if !s.Scan(line, scratch) {
 			continue
 		}
+		w.lines = append(w.lines, n)
 
 		for i := 0; i < numCols; i++ {
 			val := scratch[i]
@@ -186,10 +762,7 @@ func (w *Writer) WriteLinesSIMD(lines [][]byte, s *Scanner) (arrow.Record, error
 		if rows >= maxRows {
 			w.rows = rows
 			for i := 0; i < numCols; i++ {
-				if n := totalDataLen(w.tempColVals[i]); n > 0 {
-					builders[i].ReserveData(n)
-				}
-				builders[i].AppendValues(w.tempColVals[i], w.tempValids[i])
+				appendValues(builders[i], w.tempColVals[i], w.tempValids[i])
 			}
 			return w.Flush()
 		}
@@ -198,15 +771,16 @@ func (w *Writer) WriteLinesSIMD(lines [][]byte, s *Scanner) (arrow.Record, error
 	w.rows = rows
 	if len(w.tempColVals[0]) > 0 {
 		for i := 0; i < numCols; i++ {
-			if n := totalDataLen(w.tempColVals[i]); n > 0 {
-				builders[i].ReserveData(n)
-			}
-			builders[i].AppendValues(w.tempColVals[i], w.tempValids[i])
+			appendValues(builders[i], w.tempColVals[i], w.tempValids[i])
 		}
 	}
 	return nil, nil
 }
 
+// Lines returns the index in lines of each row the last WriteLinesSIMD
+// call appended, in order. It is valid until the next call.
+func (w *Writer) Lines() []int { return w.lines }
+
 func totalDataLen(vals [][]byte) int {
 	n := 0
 	for _, v := range vals {
@@ -246,7 +820,8 @@ func (w *Writer) Flush() (arrow.Record, error) {
 
 type ArrowWriter struct {
 	schema      *arrow.Schema
-	builders    []*array.BinaryBuilder
+	builders    []array.Builder
+	value       [1][]byte
 	mem         memory.Allocator
 	maxRows     int
 	rowsInBatch int
@@ -256,16 +831,21 @@ func NewArrowWriter(schema *arrow.Schema, mem memory.Allocator, maxRows int) *Ar
 	if mem == nil {
 		mem = memory.DefaultAllocator
 	}
-	builders := make([]*array.BinaryBuilder, len(schema.Fields()))
-	for i := range builders {
-		builders[i] = array.NewBinaryBuilder(mem, arrow.BinaryTypes.Binary)
+	builders := make([]array.Builder, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		builders[i] = newColumnBuilder(mem, f)
 	}
 	return &ArrowWriter{schema: schema, builders: builders, mem: mem, maxRows: maxRows}
 }
 
 func (w *ArrowWriter) Append(values []string) {
 	for i, v := range values {
-		w.builders[i].Append([]byte(v))
+		if b, ok := w.builders[i].(*array.BinaryBuilder); ok {
+			b.Append([]byte(v))
+			continue
+		}
+		w.value[0] = []byte(v)
+		appendValues(w.builders[i], w.value[:], nil)
 	}
 	w.rowsInBatch++
 }
@@ -295,56 +875,27 @@ func (w *ArrowWriter) Flush() arrow.Record {
 // Internal helpers
 // ============================================================
 
-type fieldVM struct {
-	delim  byte
-	isLast bool
-}
-
-func buildScanPlan(re *regexp.Regexp, numFields int) ([]fieldVM, error) {
-	ast, err := syntax.Parse(re.String(), syntax.Perl)
-	if err != nil {
-		return nil, err
-	}
-
-	fields := make([]fieldVM, 0, numFields)
-	var literals []byte
-
-	if ast.Op == syntax.OpConcat {
-		for _, n := range ast.Sub {
-			if n.Op == syntax.OpLiteral && len(n.Rune) > 0 {
-				literals = append(literals, byte(n.Rune[0]))
-			}
-		}
-	}
-
-	litIdx := 0
-	for i := 0; i < numFields; i++ {
-		vm := fieldVM{}
-		if litIdx < len(literals) {
-			vm.delim = literals[litIdx]
-			litIdx++
-		} else {
-			vm.isLast = true
-		}
-		fields = append(fields, vm)
-	}
-	return fields, nil
-}
-
-// ExtractSchema is kept for external use (uses Binary for raw captures).
+// ExtractSchema returns the columns of the named groups of re. They are
+// Binary unless the group name is annotated with a type, as in
+// (?P<status__int32>\d+), which gives an int32 column named status.
 func ExtractSchema(re *regexp.Regexp) (*arrow.Schema, error) {
 	if re == nil {
 		return nil, errors.New("nil regexp")
 	}
 	names := re.SubexpNames()
 	fields := make([]arrow.Field, 0, len(names))
+	groups := map[string]string{} // column name -> group name
 	for i := 1; i < len(names); i++ {
-		if names[i] != "" {
-			fields = append(fields, arrow.Field{
-				Name: names[i],
-				Type: arrow.BinaryTypes.Binary,
-			})
+		if names[i] == "" {
+			continue
+		}
+		f := columnField(names[i])
+		// regexp allows repeated names; only annotations make a clash
+		if prev, ok := groups[f.Name]; ok && (prev != f.Name || names[i] != f.Name) {
+			return nil, fmt.Errorf("carve: groups %q and %q are both column %q", prev, names[i], f.Name)
 		}
+		groups[f.Name] = names[i]
+		fields = append(fields, f)
 	}
 	if len(fields) == 0 {
 		return nil, errors.New("pattern must contain named capture groups")
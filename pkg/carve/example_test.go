@@ -2,9 +2,12 @@ package carve_test
 
 import (
 	"fmt"
+	"io"
 	"regexp"
+	"strings"
 
 	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
 	"github.com/apache/arrow-go/v18/arrow/memory"
 
 	"carve/pkg/carve"
@@ -257,3 +260,80 @@ func Example_memoryManagement() {
 	// Released record 1
 	// Released record 2
 }
+
+// ExampleGrok demonstrates building a scanner from a grok pattern
+func ExampleGrok() {
+	g := carve.NewGrok()
+	g.AddPattern("SERVICE", `[a-z]+-svc`)
+
+	scanner, err := g.New(`^%{SERVICE:service} %{IPORHOST:client} %{NUMBER:bytes:int}$`)
+	if err != nil {
+		panic(err)
+	}
+
+	out := make([][]byte, len(scanner.Schema().Fields()))
+	scanner.Scan([]byte("auth-svc 10.0.0.1 512"), out)
+	for i, field := range scanner.Schema().Fields() {
+		fmt.Printf("%s=%s\n", field.Name, out[i])
+	}
+
+	// Output:
+	// service=auth-svc
+	// client=10.0.0.1
+	// bytes=512
+}
+
+// ExampleNewTemplate demonstrates building a scanner without a regex
+func ExampleNewTemplate() {
+	scanner, err := carve.NewTemplate("{ts} [{thread}] {level} {msg...}")
+	if err != nil {
+		panic(err)
+	}
+
+	out := make([][]byte, len(scanner.Schema().Fields()))
+	scanner.Scan([]byte("2023-01-01T10:00:00Z [main] INFO Application started"), out)
+	for i, field := range scanner.Schema().Fields() {
+		fmt.Printf("%s=%s\n", field.Name, out[i])
+	}
+
+	// Output:
+	// ts=2023-01-01T10:00:00Z
+	// thread=main
+	// level=INFO
+	// msg=Application started
+}
+
+func ExampleLineReader() {
+	scanner, err := carve.New(`^(?P<ts>[^ ]+) (?P<level>\w+) (?P<msg>.+)`)
+	if err != nil {
+		panic(err)
+	}
+	scanner.WithOptions(carve.Options{ZeroCopy: true, TrimCR: true})
+	writer := carve.NewWriter(scanner.Schema(), memory.DefaultAllocator, 8192)
+
+	input := strings.NewReader("2023-01-01T10:00:00Z INFO started\r\n2023-01-01T10:00:01Z WARN slow\r\n")
+	lr := carve.NewLineReader(input, 0)
+	for {
+		lines, err := lr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			panic(err)
+		}
+		if _, err := writer.WriteLinesSIMD(lines, scanner); err != nil {
+			panic(err)
+		}
+	}
+
+	rec, err := writer.Flush()
+	if err != nil {
+		panic(err)
+	}
+	defer rec.Release()
+	fmt.Println(rec.NumRows(), "rows")
+	fmt.Printf("%s\n", rec.Column(2).(*array.Binary).Value(1))
+
+	// Output:
+	// 2 rows
+	// slow
+}
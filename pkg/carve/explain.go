@@ -0,0 +1,226 @@
+package carve
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ============================================================
+// Plan introspection
+// ============================================================
+
+// Plan describes what New compiled a pattern into: the engine in use, the
+// instruction sequence, and the regex constructs the plan had to ignore
+// or approximate.
+type Plan struct {
+	Mode Mode
+	PlanSequence
+	Warnings []string
+}
+
+// PlanSequence is a run of field instructions between an optional
+// leading literal and an optional trailing literal.
+type PlanSequence struct {
+	Prefix    string
+	Fields    []FieldPlan
+	Suffix    string
+	AnchorEnd bool
+}
+
+// FieldPlan describes one field instruction. A skip field, which
+// consumes text the pattern does not capture, has Column -1 and no Name.
+type FieldPlan struct {
+	Name   string
+	Column int
+	// Terminator is how the end of the field is found: "delimiter",
+	// "space" (first byte of the Space run, then Delimiter), "quote"
+	// (first unescaped Quote, then Delimiter), "bracket" (first closing
+	// bracket of Brackets, then Delimiter), "class" (first byte outside
+	// Class), "suffix" (first occurrence of the sequence's Suffix), "rest"
+	// (end of line, less the Suffix) or "branch" (see Alternatives).
+	Terminator string
+	Delimiter  string
+	// Space and SpaceAfter are the whitespace runs skipped before and
+	// after Delimiter, written like `[\t ]+`.
+	Space      string
+	SpaceAfter string
+	// Brackets is the open/close pair a bracket field is enclosed in.
+	Brackets string
+	// Quote holds the quote bytes of a quoted field, and Escape how
+	// they are escaped: "backslash" or "doubled". Enclosed fields
+	// include their quotes.
+	Quote    string
+	Escape   string
+	Enclosed bool
+	Class    string
+	Min      int
+	Max      int // -1 when unbounded
+	// Verify is the sub-expression checked against the field content when
+	// it is not a plain character class.
+	Verify   string
+	Optional bool
+	Last     bool
+	// Alternatives are the continuations of a branch, tried in order.
+	Alternatives []PlanSequence
+}
+
+// Plan returns a description of the scanner's compiled plan.
+func (s *Scanner) Plan() Plan {
+	required := map[int]bool{}
+	requiredCols(&s.scanProg, required)
+	return Plan{
+		Mode:         s.mode,
+		PlanSequence: s.describe(&s.scanProg, required),
+		Warnings:     append([]string(nil), s.warnings...),
+	}
+}
+
+func (s *Scanner) describe(p *scanProg, required map[int]bool) PlanSequence {
+	seq := PlanSequence{
+		Prefix:    string(p.prefix),
+		Suffix:    string(p.suffix),
+		AnchorEnd: p.anchorEnd,
+	}
+	for i := range p.fields {
+		f := &p.fields[i]
+		fp := FieldPlan{Column: f.col, Min: f.min, Max: f.max, Last: f.isLast}
+		switch {
+		case f.alts != nil:
+			fp.Terminator = "branch"
+			for j := range f.alts {
+				fp.Alternatives = append(fp.Alternatives, s.describe(&f.alts[j], required))
+			}
+			seq.Fields = append(seq.Fields, fp)
+			continue
+		case f.isLast && f.suffixFirst:
+			fp.Terminator = "suffix"
+		case f.isLast:
+			fp.Terminator = "rest"
+		case f.byClass:
+			fp.Terminator = "class"
+		case f.pair != nil:
+			fp.Terminator = "bracket"
+			fp.Delimiter = string(f.delim)
+			fp.Brackets = string(f.pair)
+		case f.quotes != nil:
+			fp.Terminator = "quote"
+			fp.Delimiter = string(f.delim)
+		case f.lead != nil:
+			fp.Terminator = "space"
+			fp.Delimiter = string(f.delim)
+		default:
+			fp.Terminator = "delimiter"
+			fp.Delimiter = string(f.delim)
+		}
+		if f.lead != nil {
+			fp.Space = f.lead.String()
+		}
+		if f.trail != nil {
+			fp.SpaceAfter = f.trail.String()
+		}
+		if f.col >= 0 {
+			fp.Name = s.schema.Field(f.col).Name
+			fp.Optional = !required[f.col]
+		}
+		if f.hasClass {
+			fp.Class = f.class.String()
+		}
+		if f.re != nil {
+			fp.Verify = f.re.String()
+		}
+		if f.quotes != nil {
+			fp.Quote = string(f.quotes)
+			fp.Escape = "backslash"
+			if f.doubled {
+				fp.Escape = "doubled"
+			}
+			fp.Enclosed = f.enclosed
+		}
+		seq.Fields = append(seq.Fields, fp)
+	}
+	return seq
+}
+
+// requiredCols marks the columns written on every path through p.
+func requiredCols(p *scanProg, req map[int]bool) {
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.alts == nil {
+			req[f.col] = true
+			continue
+		}
+		// a column is required after a branch if every alternative writes it
+		counts := map[int]int{}
+		for j := range f.alts {
+			alt := map[int]bool{}
+			requiredCols(&f.alts[j], alt)
+			for c := range alt {
+				counts[c]++
+			}
+		}
+		for c, n := range counts {
+			if n == len(f.alts) {
+				req[c] = true
+			}
+		}
+	}
+}
+
+// String renders the set as a regex character class, negated when that
+// is shorter.
+func (s *byteSet) String() string {
+	set, neg := *s, false
+	members := 0
+	for c := 0; c < 256; c++ {
+		if s.has(byte(c)) {
+			members++
+		}
+	}
+	if members > 128 {
+		neg = true
+		for i := range set {
+			set[i] = ^set[i]
+		}
+	}
+
+	var b strings.Builder
+	b.WriteByte('[')
+	if neg {
+		b.WriteByte('^')
+	}
+	for c := 0; c < 256; {
+		if !set.has(byte(c)) {
+			c++
+			continue
+		}
+		lo := c
+		for c < 256 && set.has(byte(c)) {
+			c++
+		}
+		hi := c - 1
+		b.WriteString(classByte(byte(lo)))
+		if hi > lo+1 {
+			b.WriteByte('-')
+		}
+		if hi > lo {
+			b.WriteString(classByte(byte(hi)))
+		}
+	}
+	b.WriteByte(']')
+	return b.String()
+}
+
+func classByte(c byte) string {
+	switch {
+	case c == '\n':
+		return `\n`
+	case c == '\t':
+		return `\t`
+	case c == ']' || c == '\\' || c == '^' || c == '-' || c == '[':
+		return `\` + string(c)
+	case c < 0x20 || c >= 0x7f:
+		return fmt.Sprintf(`\x%02x`, c)
+	default:
+		return string(c)
+	}
+}
@@ -0,0 +1,345 @@
+package carve
+
+import (
+	"bytes"
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+// examplePatterns are the patterns used in example_test.go.
+var examplePatterns = []string{
+	`^(?P<timestamp>[^ ]+) (?P<level>\w+) (?P<message>.+)`,
+	`^(?P<level>\w+) (?P<message>.+)`,
+	`^(?P<timestamp>\d{4}-[^ ]+) (?P<level>\w+) (?P<message>.+)`,
+	`^(?P<ip>\S+) \S+ \S+ \[(?P<timestamp>[^\]]+)\] "(?P<method>\w+) (?P<path>\S+) (?P<protocol>[^"]+)" (?P<status>\d+) (?P<size>\d+)`,
+	`^(?P<timestamp>\d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{3}Z) \[(?P<thread>[^\]]+)\] (?P<level>\w+) (?P<logger>\S+) - (?P<message>.+)`,
+	`^(?P<timestamp>\d{4}-\d{2}-\d{2}) (?P<level>\w+) (?P<message>.+)`,
+}
+
+// exampleLines are the inputs used in example_test.go.
+var exampleLines = []string{
+	"2023-01-01T10:00:00.123Z INFO Application started",
+	"invalid log line",
+	"INFO Starting application",
+	`192.168.1.1 - - [01/Jan/2023:10:00:00 +0000] "GET /api/users HTTP/1.1" 200 1234`,
+	`2023-01-01T10:00:00.123Z [main] INFO com.example.App - Application started`,
+	"2023-01-01 INFO Valid log line",
+	"",
+}
+
+// Pattern grammar for FuzzScannerDifferential. Each byte of the spec picks
+// the next production.
+var (
+	fuzzClasses = []string{
+		`[^ ]+`, `\d+`, `\w+`, `[a-z]*`, `[^,"]*`, `.*`, `.+`, `[^\]]+`,
+		`\d{2}`, `[0-9a-f]{1,4}`, `\S+`, `[A-Z]+`, `\d{4}-[^ ]+`, `-?\d+`,
+		`(?:[^"\\]|\\.)*`, `(?:[^"]|"")*`, `"(?:[^"\\]|\\.)*"`, `"(?:[^"]|"")+"|'(?:[^']|'')*'`,
+		`[^│·]+`, `(?i:x)\d+`,
+	}
+	fuzzSeps = []string{
+		" ", " - ", ",", ": ", `" `, "] ", "|", "", " [", "=", "\t", "->",
+		`"`, `" "`, `",`, `""`, "│", " → ", "·",
+	}
+	// fuzzRuns are separators written as regexp, for whitespace runs.
+	fuzzRuns = []string{` +`, `\s+`, `[ \t]{2,}`, `: *`, `\s+-\s+`, ` *,`, `\s*`}
+)
+
+func seedLines(f *testing.F) []string {
+	data, err := os.ReadFile("../../testdata/sample.log")
+	if err != nil {
+		f.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	return append(lines, exampleLines...)
+}
+
+// FuzzScannerDifferential builds a pattern from the fuzzed spec and checks
+// that a verifying Scanner agrees with regexp on the fuzzed line whenever
+// the scanner claims its plan is exact.
+func FuzzScannerDifferential(f *testing.F) {
+	specs := [][]byte{
+		{2, 0, 0, 2, 0, 5, 0},
+		{3, 1, 1, 0, 0, 0, 4, 2, 0, 0, 1},
+		{2, 12, 0, 0, 2, 0, 0, 5, 1},
+		{1, 8, 7, 0, 9, 0, 1},
+		{4, 7, 6, 0, 0, 0, 5, 3, 0, 1, 6, 1, 0, 5},
+	}
+	for _, spec := range specs {
+		for _, line := range seedLines(f) {
+			f.Add(spec, line)
+		}
+		f.Add(spec, "12 ab, cd - 34: x")
+		f.Add(spec, `ab" 12] zz|7=q`)
+	}
+
+	f.Fuzz(func(t *testing.T, spec []byte, line string) {
+		pattern := fuzzPattern(spec)
+		s, err := New(pattern)
+		if err != nil {
+			return
+		}
+		if s.Mode() != ModeCompiled {
+			return
+		}
+		s.WithOptions(Options{ZeroCopy: true, Verify: true})
+		compareWithRegexp(t, regexp.MustCompile(pattern), s, []byte(line))
+	})
+}
+
+// FuzzScanBatchIndexed checks that the structural index path of
+// ScanBatch agrees with scanning each line on its own, for any plan,
+// exact or not, that it applies to.
+func FuzzScanBatchIndexed(f *testing.F) {
+	specs := [][]byte{
+		{2, 0, 0, 2, 0, 5, 0},
+		{3, 1, 4, 0, 0, 0, 4, 2, 0, 0, 1},
+		{2, 12, 0, 6, 2, 0, 0, 5, 1},
+	}
+	for _, spec := range specs {
+		f.Add(spec, strings.Join(seedLines(f), "\n"), false)
+		f.Add(spec, "12 ab, cd - 34: x\r\n\nab\" 12] zz|7=q", true)
+	}
+
+	f.Fuzz(func(t *testing.T, spec []byte, text string, trimCR bool) {
+		s, err := New(fuzzPattern(spec))
+		if err != nil {
+			return
+		}
+		s.WithOptions(Options{ZeroCopy: true, TrimCR: trimCR})
+		if _, ok := s.indexTables(); !ok {
+			return
+		}
+		buf := []byte(text)
+		offsets := LineOffsets(nil, buf)
+		var got, want ColumnOffsets
+		s.ScanBatch(buf, offsets, &got)
+		s.noIndex = true
+		s.ScanBatch(buf, offsets, &want)
+		s.noIndex = false
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("pattern %s, buffer %q: indexed %+v, want %+v", fuzzPattern(spec), text, got, want)
+		}
+	})
+}
+
+// FuzzScannerExamples checks the example patterns against fuzzed lines.
+func FuzzScannerExamples(f *testing.F) {
+	for _, line := range seedLines(f) {
+		f.Add(line)
+	}
+
+	scanners := make([]*Scanner, len(examplePatterns))
+	regexps := make([]*regexp.Regexp, len(examplePatterns))
+	for i, pattern := range examplePatterns {
+		s, err := New(pattern)
+		if err != nil {
+			f.Fatal(err)
+		}
+		scanners[i] = s.WithOptions(Options{ZeroCopy: true, Verify: true})
+		regexps[i] = regexp.MustCompile(pattern)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		for i, s := range scanners {
+			if s.Mode() == ModePartial {
+				continue
+			}
+			compareWithRegexp(t, regexps[i], s, []byte(line))
+		}
+	})
+}
+
+func compareWithRegexp(t *testing.T, re *regexp.Regexp, s *Scanner, line []byte) {
+	t.Helper()
+	pattern := re.String()
+	m := re.FindSubmatchIndex(line)
+	out := make([][]byte, len(s.Schema().Fields()))
+	ok := s.Scan(line, out)
+	if ok != (m != nil) {
+		t.Fatalf("pattern %s, line %q: scanner matched = %v (%v), regexp matched = %v",
+			pattern, line, ok, s.Reason(), m != nil)
+	}
+	if !ok {
+		return
+	}
+
+	col := 0
+	for i, name := range re.SubexpNames() {
+		if i == 0 || name == "" {
+			continue
+		}
+		start, end := m[2*i], m[2*i+1]
+		switch {
+		case start < 0 && out[col] != nil:
+			t.Fatalf("pattern %s, line %q: field %s: scanner got %q, regexp did not capture",
+				pattern, line, name, out[col])
+		case start >= 0 && (out[col] == nil || !bytes.Equal(out[col], line[start:end])):
+			t.Fatalf("pattern %s, line %q: field %s: scanner got %q (nil=%v), regexp got %q",
+				pattern, line, name, out[col], out[col] == nil, line[start:end])
+		}
+		col++
+	}
+}
+
+// fuzzPattern decodes spec into an anchored pattern of named fields with
+// separators, optional groups, alternations and uncaptured segments.
+func fuzzPattern(spec []byte) string {
+	next := func() int {
+		if len(spec) == 0 {
+			return 0
+		}
+		b := spec[0]
+		spec = spec[1:]
+		return int(b)
+	}
+
+	var b strings.Builder
+	b.WriteByte('^')
+	n := 1 + next()%4
+	for i := 0; i < n; i++ {
+		class := fuzzClasses[next()%len(fuzzClasses)]
+		sep := ""
+		if i < n-1 {
+			if k := next() % (len(fuzzSeps) + len(fuzzRuns)); k < len(fuzzSeps) {
+				sep = regexp.QuoteMeta(fuzzSeps[k])
+			} else {
+				sep = fuzzRuns[k-len(fuzzSeps)]
+			}
+		}
+		field := "(?P<f" + string(rune('a'+i)) + ">" + class + ")"
+
+		switch next() % 8 {
+		case 0:
+			b.WriteString("(?:" + field + sep + ")?")
+		case 1:
+			other := fuzzClasses[next()%len(fuzzClasses)]
+			b.WriteString("(?:k=" + field + "|v=(?P<g" + string(rune('a'+i)) + ">" + other + "))" + sep)
+		case 2:
+			b.WriteString("(?:" + class + ")" + sep)
+		case 3:
+			b.WriteString("(" + class + ")" + sep)
+		default:
+			b.WriteString(field + sep)
+		}
+	}
+	if next()%2 == 1 {
+		b.WriteByte('$')
+	}
+	return b.String()
+}
+
+// FuzzLineReader checks LineReader against splitting the whole input at
+// once, for any buffer size, read size and long-line policy.
+func FuzzLineReader(f *testing.F) {
+	f.Add("a\nbc\r\n\nlonger line\nend", uint8(1), uint8(3), uint8(0), uint8(1))
+	f.Add(strings.Repeat("0123456789\n", 20)+"x", uint8(16), uint8(7), uint8(10), uint8(3))
+
+	f.Fuzz(func(t *testing.T, input string, size, chunk, maxLength, policy uint8) {
+		opts := LineOptions{MaxLength: int(maxLength), Policy: LongLinePolicy(policy % 4)}
+		var want []string
+		var wantRejects strings.Builder
+		var wantErr error = io.EOF
+		var lines []string
+		if input != "" {
+			lines = strings.Split(strings.TrimSuffix(input, "\n"), "\n")
+		}
+	split:
+		for _, line := range lines {
+			switch {
+			case opts.MaxLength == 0 || len(line) <= opts.MaxLength:
+				want = append(want, line)
+			case opts.Policy == LongLineError:
+				wantErr = ErrLineTooLong
+				break split
+			case opts.Policy == LongLineTruncate:
+				want = append(want, line[:opts.MaxLength]+"*")
+			case opts.Policy == LongLineReject:
+				wantRejects.WriteString(line + "\n")
+			}
+		}
+
+		var rejects strings.Builder
+		opts.Reject = &rejects
+		r := iotest.HalfReader(&chunkReader{input, int(chunk%32) + 1})
+		lr := NewLineReader(r, int(size%64)+1).WithOptions(opts)
+		var got []string
+		var err error
+		for err == nil {
+			var lines [][]byte
+			lines, err = lr.Next()
+			for i, line := range lines {
+				if lr.Truncated(i) {
+					line = append(line, '*')
+				}
+				got = append(got, string(line))
+			}
+		}
+		if err != wantErr || !slices.Equal(got, want) || rejects.String() != wantRejects.String() {
+			t.Fatalf("%+v: lines %q, rejects %q, error %v; want %q, %q, %v",
+				opts, got, rejects.String(), err, want, wantRejects.String(), wantErr)
+		}
+	})
+}
+
+// chunkReader returns its data at most n bytes at a time.
+type chunkReader struct {
+	data string
+	n    int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if r.data == "" {
+		return 0, io.EOF
+	}
+	n := copy(p[:min(len(p), r.n)], r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// FuzzParseNumbers checks the column parsers against strconv, which also
+// takes hex and underscored forms that they reject.
+func FuzzParseNumbers(f *testing.F) {
+	for _, s := range []string{"0", "-12", "+7", "1.5", "-.5e-3", "5.", "1e400", "NaN", "-Inf", "0x10", "1_0", "e3", "."} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		other := strings.ContainsAny(s, "_xXpP")
+		i, ok := parseInt([]byte(s))
+		wi, err := strconv.ParseInt(s, 10, 64)
+		if ok != (err == nil && !other) || ok && i != wi {
+			t.Fatalf("parseInt(%q) = %d, %v; strconv: %d, %v", s, i, ok, wi, err)
+		}
+		x, ok := parseFloat64([]byte(s))
+		wx, err := strconv.ParseFloat(s, 64)
+		if ok != (err == nil && !other) || ok && x != wx && !(math.IsNaN(x) && math.IsNaN(wx)) {
+			t.Fatalf("parseFloat64(%q) = %v, %v; strconv: %v, %v", s, x, ok, wx, err)
+		}
+	})
+}
+
+// FuzzParseISO8601 checks the ISO 8601 parser against time.Parse on the
+// RFC 3339 times both take. time.Parse also takes one-digit hours.
+func FuzzParseISO8601(f *testing.F) {
+	for _, s := range []string{"2023-01-01T10:00:00Z", "2023-01-01T10:00:00.123+02:00", "2024-02-29T23:59:59.999999999-11:30", "0000-03-01T00:00:00Z"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, s string) {
+		want, err := time.Parse(time.RFC3339Nano, s)
+		sec, nsec, ok := parseISO8601([]byte(s), time.UTC)
+		if err != nil || len(s) < 19 || s[13] != ':' {
+			return
+		}
+		if !ok || sec != want.Unix() || nsec != int64(want.Nanosecond()) {
+			t.Fatalf("parseISO8601(%q) = %d, %d, %v; want %v", s, sec, nsec, ok, want)
+		}
+	})
+}
@@ -0,0 +1,270 @@
+package carve
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ============================================================
+// Grok front end
+// ============================================================
+
+// Grok expands grok-style patterns such as
+//
+//	%{IPORHOST:client} %{NUMBER:bytes:int}
+//
+// into carve patterns. %{NAME} inlines a library pattern, %{NAME:field}
+// captures it as a named field and %{NAME:field:type} also gives the
+// field's column a type: int, float or one of the names SchemaWithTypes
+// takes. The zero value has an empty library; NewGrok starts from the
+// built-in one.
+type Grok struct {
+	patterns map[string]string
+}
+
+// grokRef matches a pattern reference. Field names and types are matched
+// loosely so that invalid ones can be reported instead of being left in
+// the expression as literals.
+var grokRef = regexp.MustCompile(`%\{(\w+)(?::([^:}]*))?(?::([^:}]*))?\}`)
+
+var grokName = regexp.MustCompile(`^\w+$`)
+
+// NewGrok returns a Grok with the built-in pattern library.
+func NewGrok() *Grok {
+	return &Grok{patterns: maps.Clone(grokLibrary)}
+}
+
+// AddPattern defines or replaces a library pattern. The definition may
+// refer to other patterns; references are resolved when a pattern is
+// expanded.
+func (g *Grok) AddPattern(name, pattern string) error {
+	if !grokName.MatchString(name) {
+		return fmt.Errorf("grok: invalid pattern name %q", name)
+	}
+	if g.patterns == nil {
+		g.patterns = map[string]string{}
+	}
+	g.patterns[name] = pattern
+	return nil
+}
+
+// AddPatterns reads definitions in the Logstash pattern file format: one
+// "NAME pattern" per line, with blank lines and lines starting with #
+// ignored.
+func (g *Grok) AddPatterns(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	for lineNum := 1; sc.Scan(); lineNum++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		name, pattern, ok := strings.Cut(line, " ")
+		if !ok {
+			return fmt.Errorf("grok: line %d: missing pattern after %q", lineNum, name)
+		}
+		if err := g.AddPattern(name, strings.TrimSpace(pattern)); err != nil {
+			return fmt.Errorf("line %d: %w", lineNum, err)
+		}
+	}
+	return sc.Err()
+}
+
+// AddPatternsFrom loads a pattern file, or every regular file in a
+// directory in name order, like Logstash's patterns_dir.
+func (g *Grok) AddPatternsFrom(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		files = files[:0]
+		for _, e := range entries {
+			if e.Type().IsRegular() {
+				files = append(files, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		err = g.AddPatterns(f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return nil
+}
+
+// Expand resolves every reference in pattern and returns the resulting
+// regular expression together with the type hint of each field that has
+// one.
+func (g *Grok) Expand(pattern string) (string, map[string]string, error) {
+	e := grokExpansion{g: g, fields: map[string]bool{}, types: map[string]string{}}
+	expr, err := e.expand(pattern, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	return expr, e.types, nil
+}
+
+// New expands pattern and compiles the result with New, typing the
+// columns of fields with a type hint.
+func (g *Grok) New(pattern string) (*Scanner, error) {
+	expr, types, err := g.Expand(pattern)
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(expr)
+	if err != nil {
+		return nil, err
+	}
+	return s.WithTypes(types)
+}
+
+type grokExpansion struct {
+	g      *Grok
+	fields map[string]bool
+	types  map[string]string
+}
+
+// expand rewrites the references in pattern. stack holds the library
+// patterns being expanded, to reject definitions that refer to
+// themselves.
+func (e *grokExpansion) expand(pattern string, stack []string) (string, error) {
+	var b strings.Builder
+	last := 0
+	for _, m := range grokRef.FindAllStringSubmatchIndex(pattern, -1) {
+		b.WriteString(pattern[last:m[0]])
+		last = m[1]
+
+		name := pattern[m[2]:m[3]]
+		def, ok := e.g.patterns[name]
+		if !ok {
+			return "", fmt.Errorf("grok: unknown pattern %%{%s}", name)
+		}
+		if slices.Contains(stack, name) {
+			return "", fmt.Errorf("grok: pattern %s refers to itself through %s", name, strings.Join(stack, " -> "))
+		}
+		sub, err := e.expand(def, append(stack, name))
+		if err != nil {
+			return "", err
+		}
+
+		if m[4] < 0 {
+			b.WriteString("(?:" + sub + ")")
+			continue
+		}
+		field := pattern[m[4]:m[5]]
+		if !grokName.MatchString(field) {
+			return "", fmt.Errorf("grok: invalid field name %q in %s", field, pattern[m[0]:m[1]])
+		}
+		if e.fields[field] {
+			return "", fmt.Errorf("grok: field %q is captured more than once", field)
+		}
+		e.fields[field] = true
+		if m[6] >= 0 {
+			typ := pattern[m[6]:m[7]]
+			if _, err := typedField(field, typ); err != nil {
+				return "", fmt.Errorf("grok: unknown type %q for field %q", typ, field)
+			}
+			e.types[field] = typ
+		}
+		b.WriteString("(?P<" + field + ">" + sub + ")")
+	}
+	b.WriteString(pattern[last:])
+	return b.String(), nil
+}
+
+// grokLibrary is the built-in pattern library. It follows the Logstash
+// core patterns, rewritten for RE2: no lookaround, atomic groups or word
+// boundaries, which the scanner would have to ignore or fall back to
+// regexp for.
+var grokLibrary = map[string]string{
+	// basic
+	"USERNAME":     `[a-zA-Z0-9._-]+`,
+	"USER":         `%{USERNAME}`,
+	"INT":          `[+-]?\d+`,
+	"BASE10NUM":    `[+-]?(?:\d+(?:\.\d+)?|\.\d+)`,
+	"NUMBER":       `%{BASE10NUM}`,
+	"BASE16NUM":    `[+-]?(?:0x)?[0-9A-Fa-f]+`,
+	"POSINT":       `[1-9]\d*`,
+	"NONNEGINT":    `\d+`,
+	"WORD":         `\w+`,
+	"NOTSPACE":     `\S+`,
+	"SPACE":        `\s*`,
+	"DATA":         `.*?`,
+	"GREEDYDATA":   `.*`,
+	"QUOTEDSTRING": `"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'`,
+	"QS":           `%{QUOTEDSTRING}`,
+	"UUID":         `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+
+	// networking
+	"MAC":        `%{CISCOMAC}|%{WINDOWSMAC}|%{COMMONMAC}`,
+	"CISCOMAC":   `(?:[A-Fa-f0-9]{4}\.){2}[A-Fa-f0-9]{4}`,
+	"WINDOWSMAC": `(?:[A-Fa-f0-9]{2}-){5}[A-Fa-f0-9]{2}`,
+	"COMMONMAC":  `(?:[A-Fa-f0-9]{2}:){5}[A-Fa-f0-9]{2}`,
+	"IPV4":       `(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)`,
+	// IPV6 accepts colon-separated hex groups with an optional trailing
+	// dotted quad; it is looser than the Logstash pattern.
+	"IPV6":     `(?:[0-9A-Fa-f]{0,4}:){2,7}(?:[0-9A-Fa-f]{1,4}|%{IPV4})?`,
+	"IP":       `%{IPV6}|%{IPV4}`,
+	"HOSTNAME": `[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?`,
+	"HOST":     `%{HOSTNAME}`,
+	"IPORHOST": `%{IP}|%{HOSTNAME}`,
+	"HOSTPORT": `%{IPORHOST}:%{POSINT}`,
+
+	// paths and URIs
+	"PATH":         `%{UNIXPATH}|%{WINPATH}`,
+	"UNIXPATH":     `(?:/[\w%!$@:.,+~-]*)+`,
+	"WINPATH":      `(?:[A-Za-z]+:|\\)(?:\\[^\\?*]*)+`,
+	"URIPROTO":     `[A-Za-z][A-Za-z0-9+.-]+`,
+	"URIHOST":      `%{IPORHOST}(?::%{POSINT})?`,
+	"URIPATH":      `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_-]*)+`,
+	"URIPARAM":     `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\[\]<>-]*`,
+	"URIPATHPARAM": `%{URIPATH}(?:%{URIPARAM})?`,
+	"URI":          `%{URIPROTO}://(?:%{USER}(?::[^@]*)?@)?%{URIHOST}(?:%{URIPATHPARAM})?`,
+
+	// dates and times
+	"MONTH":             `Jan(?:uary)?|Feb(?:ruary)?|Mar(?:ch)?|Apr(?:il)?|May|Jun(?:e)?|Jul(?:y)?|Aug(?:ust)?|Sep(?:tember)?|Oct(?:ober)?|Nov(?:ember)?|Dec(?:ember)?`,
+	"MONTHNUM":          `0?[1-9]|1[0-2]`,
+	"MONTHDAY":          `0[1-9]|[12]\d|3[01]|[1-9]`,
+	"DAY":               `Mon(?:day)?|Tue(?:sday)?|Wed(?:nesday)?|Thu(?:rsday)?|Fri(?:day)?|Sat(?:urday)?|Sun(?:day)?`,
+	"YEAR":              `\d\d(?:\d\d)?`,
+	"HOUR":              `2[0-3]|[01]?\d`,
+	"MINUTE":            `[0-5]\d`,
+	"SECOND":            `(?:[0-5]?\d|60)(?:[:.,]\d+)?`,
+	"TIME":              `%{HOUR}:%{MINUTE}(?::%{SECOND})?`,
+	"ISO8601_TIMEZONE":  `Z|[+-]%{HOUR}(?::?%{MINUTE})`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?(?:%{ISO8601_TIMEZONE})?`,
+	"DATE_US":           `%{MONTHNUM}[/-]%{MONTHDAY}[/-]%{YEAR}`,
+	"DATE_EU":           `%{MONTHDAY}[./-]%{MONTHNUM}[./-]%{YEAR}`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"SYSLOGTIMESTAMP":   `%{MONTH} +%{MONTHDAY} %{TIME}`,
+
+	// logs
+	"LOGLEVEL":          `[Aa]lert|ALERT|[Tt]race|TRACE|[Dd]ebug|DEBUG|[Nn]otice|NOTICE|[Ii]nfo|INFO|[Ww]arn(?:ing)?|WARN(?:ING)?|[Ee]rr(?:or)?|ERR(?:OR)?|[Cc]rit(?:ical)?|CRIT(?:ICAL)?|[Ff]atal|FATAL|[Ss]evere|SEVERE|[Ee]merg(?:ency)?|EMERG(?:ENCY)?`,
+	"EMAILLOCALPART":    `[A-Za-z0-9._%+-]+`,
+	"EMAILADDRESS":      `%{EMAILLOCALPART}@%{HOSTNAME}`,
+	"HTTPDUSER":         `%{EMAILADDRESS}|%{USER}`,
+	"SYSLOGPROG":        `%{PROG:program}(?:\[%{POSINT:pid}\])?`,
+	"PROG":              `[\w._/%-]+`,
+	"SYSLOGBASE":        `%{SYSLOGTIMESTAMP:timestamp} %{IPORHOST:logsource} %{SYSLOGPROG}:`,
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{HTTPDUSER:ident} %{HTTPDUSER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response} (?:%{NUMBER:bytes}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+}
@@ -0,0 +1,217 @@
+package carve
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+func TestGrokExpand(t *testing.T) {
+	g := NewGrok()
+	expr, types, err := g.Expand(`^%{IPORHOST:client} %{NUMBER:bytes:int} %{WORD}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	re := regexp.MustCompile(expr)
+	if got := strings.Join(re.SubexpNames()[1:], ","); !strings.HasPrefix(got, "client,") || !strings.HasSuffix(got, ",bytes") {
+		t.Fatalf("capture names = %q", got)
+	}
+	if len(types) != 1 || types["bytes"] != "int" {
+		t.Fatalf("types = %v, want bytes:int", types)
+	}
+
+	m := re.FindStringSubmatch("example.com 1234 ok")
+	if m == nil {
+		t.Fatalf("%s does not match", expr)
+	}
+	if m[re.SubexpIndex("client")] != "example.com" || m[re.SubexpIndex("bytes")] != "1234" {
+		t.Fatalf("submatches = %q", m)
+	}
+}
+
+func TestGrokErrors(t *testing.T) {
+	g := NewGrok()
+	g.AddPattern("LOOP", `a%{LOOP2}`)
+	g.AddPattern("LOOP2", `b%{LOOP}`)
+
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{`%{NOSUCH:a}`, "unknown pattern %{NOSUCH}"},
+		{`%{LOOP}`, "refers to itself"},
+		{`%{WORD:a} %{WORD:a}`, `field "a" is captured more than once`},
+		{`%{WORD:a:string}`, `unknown type "string"`},
+		{`%{WORD:[a][b]}`, `invalid field name "[a][b]"`},
+	}
+	for _, tt := range tests {
+		_, _, err := g.Expand(tt.pattern)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expand(%q) error = %v, want %q", tt.pattern, err, tt.want)
+		}
+	}
+
+	if err := g.AddPattern("BAD NAME", "x"); err == nil {
+		t.Error("AddPattern accepted an invalid name")
+	}
+}
+
+func TestGrokLibraryCompiles(t *testing.T) {
+	g := NewGrok()
+	for name := range grokLibrary {
+		expr, _, err := g.Expand("(?P<x>%{" + name + "})")
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if _, err := regexp.Compile(expr); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+	}
+}
+
+func TestGrokLibraryMatches(t *testing.T) {
+	tests := []struct {
+		name  string
+		match []string
+		not   []string
+	}{
+		{"IPV4", []string{"192.168.1.1", "0.0.0.0"}, []string{"256.1.1.1", "1.2.3"}},
+		{"IPV6", []string{"::1", "fe80::1", "2001:db8:0:0:0:0:2:1", "::ffff:10.0.0.1"}, []string{"1.2.3.4", "abc"}},
+		{"IPORHOST", []string{"10.0.0.1", "api.example.com", "localhost"}, []string{"-bad"}},
+		{"NUMBER", []string{"42", "-3.5", ".5", "+7"}, []string{"1e5", "abc"}},
+		{"HTTPDATE", []string{"01/Jan/2023:10:00:00 +0000"}, []string{"2023-01-01 10:00:00"}},
+		{"TIMESTAMP_ISO8601", []string{"2023-01-01T10:00:00.123Z", "2023-01-01 10:00:00+02:00", "2023-01-01T10:00"}, []string{"01/Jan/2023"}},
+		{"LOGLEVEL", []string{"INFO", "warn", "Warning", "ERROR", "err", "DEBUG", "FATAL"}, []string{"LOUD"}},
+		{"UUID", []string{"123e4567-e89b-12d3-a456-426614174000"}, []string{"123e4567"}},
+		{"SYSLOGTIMESTAMP", []string{"Jan  1 10:00:00", "Dec 31 23:59:59"}, []string{"2023-01-01"}},
+		{"URIPATHPARAM", []string{"/api/users?id=1&x=y", "/"}, []string{"api"}},
+	}
+
+	g := NewGrok()
+	for _, tt := range tests {
+		expr, _, err := g.Expand("^(?:%{" + tt.name + "})$")
+		if err != nil {
+			t.Fatal(err)
+		}
+		re := regexp.MustCompile(expr)
+		for _, s := range tt.match {
+			if !re.MatchString(s) {
+				t.Errorf("%s does not match %q", tt.name, s)
+			}
+		}
+		for _, s := range tt.not {
+			if re.MatchString(s) {
+				t.Errorf("%s matches %q", tt.name, s)
+			}
+		}
+	}
+}
+
+func TestGrokPatternFiles(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("a", "# service patterns\n\nSERVICE [a-z]+-svc\n")
+	write("b", "REQID req-%{INT}\n")
+
+	g := NewGrok()
+	if err := g.AddPatternsFrom(dir); err != nil {
+		t.Fatal(err)
+	}
+	s, err := g.New(`^%{SERVICE:service} %{REQID:req} %{GREEDYDATA:msg}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{ZeroCopy: true, Verify: true})
+
+	out := make([][]byte, 3)
+	if !s.Scan([]byte("auth-svc req-42 user logged in"), out) {
+		t.Fatalf("Scan rejected line: %v", s.Reason())
+	}
+	if string(out[0]) != "auth-svc" || string(out[1]) != "req-42" || string(out[2]) != "user logged in" {
+		t.Fatalf("Scan = %q", out)
+	}
+	if s.Scan([]byte("auth req-42 user logged in"), out) {
+		t.Fatal("Scan accepted a line with a malformed service")
+	}
+
+	write("c", "BROKEN\n")
+	err = g.AddPatternsFrom(dir)
+	if err == nil || !strings.Contains(err.Error(), "line 1: missing pattern") {
+		t.Fatalf("AddPatternsFrom error = %v", err)
+	}
+}
+
+func TestGrokScanner(t *testing.T) {
+	s, err := NewGrok().New(`^%{IPORHOST:client} %{NUMBER:bytes:int}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Mode() != ModeCompiled {
+		t.Fatalf("Mode = %v, warnings %q", s.Mode(), s.Plan().Warnings)
+	}
+	if typ := s.Schema().Field(1).Type; typ != arrow.PrimitiveTypes.Int64 {
+		t.Fatalf("bytes column has type %s, want int64", typ)
+	}
+	s.WithOptions(Options{ZeroCopy: true, Verify: true})
+
+	out := make([][]byte, 2)
+	if !s.Scan([]byte("10.0.0.1 512"), out) {
+		t.Fatalf("Scan rejected line: %v", s.Reason())
+	}
+	if string(out[0]) != "10.0.0.1" || string(out[1]) != "512" {
+		t.Fatalf("Scan = %q", out)
+	}
+	if s.Scan([]byte("10.0.0.1 lots"), out) {
+		t.Fatal("Scan accepted a non-numeric byte count")
+	}
+}
+
+func TestGrokTimestampHints(t *testing.T) {
+	s, err := NewGrok().New(`^%{TIMESTAMP_ISO8601:ts:timestamp} %{INT:at:epoch_ms} %{GREEDYDATA:msg}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, unit := range []arrow.TimeUnit{arrow.Microsecond, arrow.Millisecond} {
+		f := s.Schema().Field(i)
+		if typ, ok := f.Type.(*arrow.TimestampType); !ok || typ.Unit != unit {
+			t.Errorf("%s column has type %s, want a timestamp in %s", f.Name, f.Type, unit)
+		}
+	}
+}
+
+func TestGrokApacheLog(t *testing.T) {
+	s, err := NewGrok().New(`^%{COMMONAPACHELOG}$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{ZeroCopy: true, Verify: true})
+
+	out := make([][]byte, len(s.Schema().Fields()))
+	line := `192.168.1.1 - frank [01/Jan/2023:10:00:00 +0000] "GET /api/users HTTP/1.1" 200 -`
+	if !s.Scan([]byte(line), out) {
+		t.Fatalf("Scan rejected line: %v", s.Reason())
+	}
+	want := map[string]string{
+		"clientip": "192.168.1.1", "ident": "-", "auth": "frank",
+		"timestamp": "01/Jan/2023:10:00:00 +0000", "verb": "GET",
+		"request": "/api/users", "httpversion": "1.1", "response": "200",
+	}
+	for i, f := range s.Schema().Fields() {
+		if w, ok := want[f.Name]; ok && string(out[i]) != w {
+			t.Errorf("%s = %q, want %q", f.Name, out[i], w)
+		}
+		if (f.Name == "bytes" || f.Name == "rawrequest") && out[i] != nil {
+			t.Errorf("%s = %q, want nil", f.Name, out[i])
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package carve
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+// ============================================================
+// Line reader
+// ============================================================
+
+// defaultLineBufferSize is the buffer size NewLineReader uses when given
+// none.
+const defaultLineBufferSize = 1 << 20
+
+// maxEmptyReads is how many reads in a row may return nothing before
+// LineReader gives up, as in bufio.
+const maxEmptyReads = 100
+
+// ErrLineTooLong is returned by LineReader.Next for a line longer than
+// LineOptions.MaxLength under LongLineError.
+var ErrLineTooLong = errors.New("carve: line too long")
+
+// LongLinePolicy says what a LineReader does with a line longer than
+// LineOptions.MaxLength.
+type LongLinePolicy uint8
+
+const (
+	LongLineError    LongLinePolicy = iota // Next fails with ErrLineTooLong
+	LongLineTruncate                       // the line is cut to MaxLength bytes and Truncated reports it
+	LongLineSkip                           // the line is dropped
+	LongLineReject                         // the line is dropped and written to LineOptions.Reject
+)
+
+func (p LongLinePolicy) String() string {
+	switch p {
+	case LongLineError:
+		return "error"
+	case LongLineTruncate:
+		return "truncate"
+	case LongLineSkip:
+		return "skip"
+	case LongLineReject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+// LineOptions bound the length of the lines a LineReader returns.
+type LineOptions struct {
+	// MaxLength is the length of the longest line returned, not counting
+	// its \n; 0 means no limit. The buffer does not grow past it, as the
+	// rest of a longer line is not kept.
+	MaxLength int
+	// Policy says what happens to longer lines. LongLines counts them
+	// whatever it is.
+	Policy LongLinePolicy
+	// Reject receives the lines LongLineReject drops, each with its \n.
+	// A write error ends the input like a read error.
+	Reject io.Writer
+}
+
+var newline = []byte{'\n'}
+
+// LineReader splits the input of an io.Reader into newline-terminated
+// lines, a buffer at a time. Unlike bufio.Scanner it yields each buffer's
+// lines as a batch of slices of it, which Writer.WriteLinesSIMD and
+// Scanner.Scan take as they are, so reading does not allocate per line.
+// A line that does not fit grows the buffer, up to LineOptions.MaxLength.
+type LineReader struct {
+	r       io.Reader
+	opts    LineOptions
+	buf     []byte
+	start   int // buf[start:end] is read but not returned yet
+	end     int
+	err     error
+	discard bool // buf[start:end] continues a long line already dealt with
+	long    int
+	lines   [][]byte
+	cut     []bool // per line, under LongLineTruncate
+}
+
+// NewLineReader returns a LineReader reading r into a buffer of size
+// bytes, 1 MiB if size is not positive.
+func NewLineReader(r io.Reader, size int) *LineReader {
+	if size <= 0 {
+		size = defaultLineBufferSize
+	}
+	return &LineReader{r: r, buf: make([]byte, size)}
+}
+
+// WithOptions sets the options of lr and returns it.
+func (lr *LineReader) WithOptions(opts LineOptions) *LineReader {
+	lr.opts = opts
+	return lr
+}
+
+// Reset makes lr read from r, discarding what it had buffered and its
+// count of long lines but keeping the buffer and options.
+func (lr *LineReader) Reset(r io.Reader) {
+	lr.r = r
+	lr.start, lr.end, lr.err = 0, 0, nil
+	lr.discard, lr.long = false, 0
+	lr.lines, lr.cut = lr.lines[:0], lr.cut[:0]
+}
+
+// Next returns the complete lines of the next buffer of input, without
+// their \n. The lines are slices of the reader's buffer, valid until the
+// following call; a \r before the \n is left for Options.TrimCR. A line
+// cut off by the end of the buffer is returned whole with the next batch,
+// and a final line without a newline with the last. Once the input is
+// exhausted Next returns nil and io.EOF, or the error that ended it, in
+// which case a final incomplete line is dropped. Under LongLineError a
+// line longer than LineOptions.MaxLength ends the input with
+// ErrLineTooLong, once the lines before it have been returned.
+func (lr *LineReader) Next() ([][]byte, error) {
+	lr.lines, lr.cut = lr.lines[:0], lr.cut[:0]
+	for len(lr.lines) == 0 {
+		if lr.start == lr.end && lr.err != nil {
+			return nil, lr.err
+		}
+		lr.fill()
+		lr.split()
+	}
+	return lr.lines, nil
+}
+
+// Truncated reports whether line i of the last batch was cut to
+// LineOptions.MaxLength.
+func (lr *LineReader) Truncated(i int) bool {
+	return i < len(lr.cut) && lr.cut[i]
+}
+
+// LongLines returns the number of lines longer than LineOptions.MaxLength
+// read so far, whatever the policy did with them.
+func (lr *LineReader) LongLines() int { return lr.long }
+
+// fill reads until a line is complete, the input ends or the line grows
+// past the limit. Only the bytes just read need looking at: split left
+// the ones before them for having no \n.
+func (lr *LineReader) fill() {
+	if lr.start > 0 {
+		lr.end = copy(lr.buf, lr.buf[lr.start:lr.end])
+		lr.start = 0
+	}
+	for empty := 0; lr.err == nil; {
+		if lr.end == len(lr.buf) {
+			// Over the limit fill would have returned, so the buffer
+			// is still shorter than MaxLength+1 here.
+			size := 2 * len(lr.buf)
+			if max := lr.opts.MaxLength; max > 0 && size > max+1 {
+				size = max + 1
+			}
+			buf := make([]byte, size)
+			copy(buf, lr.buf[:lr.end])
+			lr.buf = buf
+		}
+		n, err := lr.r.Read(lr.buf[lr.end:])
+		lr.end += n
+		lr.err = err
+		if n > 0 {
+			if lr.discard || lr.over(lr.end) || bytes.IndexByte(lr.buf[lr.end-n:lr.end], '\n') >= 0 {
+				return
+			}
+			empty = 0
+		} else if empty++; empty == maxEmptyReads {
+			lr.err = io.ErrNoProgress
+		}
+	}
+}
+
+// over reports whether a line of n bytes is longer than the limit.
+func (lr *LineReader) over(n int) bool {
+	return lr.opts.MaxLength > 0 && n > lr.opts.MaxLength
+}
+
+// split moves the complete lines in buf[start:end] to the batch, and the
+// incomplete one if the input has ended or it is already too long.
+func (lr *LineReader) split() {
+	data := lr.buf[:lr.end]
+	pos := lr.start
+	if lr.discard {
+		i := bytes.IndexByte(data[pos:], '\n')
+		switch {
+		case i >= 0:
+			lr.reject(data[pos : pos+i+1])
+			pos += i + 1
+			lr.discard = false
+		case lr.err != nil:
+			lr.reject(data[pos:])
+			lr.reject(newline)
+			pos = len(data)
+			lr.discard = false
+		default:
+			lr.reject(data[pos:])
+			pos = len(data)
+		}
+	}
+	// Stop at a long line under LongLineError or a failed reject, not
+	// at the read error that came with the data.
+	for ended := lr.err; lr.err == ended; {
+		i := bytes.IndexByte(data[pos:], '\n')
+		if i < 0 {
+			if rest := data[pos:]; len(rest) > 0 && (lr.err == io.EOF || lr.over(len(rest))) {
+				lr.add(rest, lr.err == io.EOF)
+				pos = len(data)
+			}
+			break
+		}
+		lr.add(data[pos:pos+i], true)
+		pos += i + 1
+	}
+	lr.start = pos
+	if lr.err != nil && lr.err != io.EOF {
+		lr.start, lr.discard = lr.end, false
+	}
+}
+
+// add appends line to the batch, applying the policy if it is too long.
+// The rest of an incomplete line is discarded as it is read.
+func (lr *LineReader) add(line []byte, complete bool) {
+	if !lr.over(len(line)) {
+		lr.lines = append(lr.lines, line)
+		if lr.opts.Policy == LongLineTruncate {
+			lr.cut = append(lr.cut, false)
+		}
+		return
+	}
+	lr.long++
+	switch lr.opts.Policy {
+	case LongLineError:
+		lr.err = ErrLineTooLong
+		return
+	case LongLineTruncate:
+		lr.lines = append(lr.lines, line[:lr.opts.MaxLength])
+		lr.cut = append(lr.cut, true)
+	case LongLineReject:
+		lr.reject(line)
+		if complete {
+			lr.reject(newline)
+		}
+	}
+	lr.discard = !complete
+}
+
+// reject writes b to the reject sink under LongLineReject.
+func (lr *LineReader) reject(b []byte) {
+	if lr.opts.Policy != LongLineReject || lr.opts.Reject == nil {
+		return
+	}
+	if _, err := lr.opts.Reject.Write(b); err != nil && (lr.err == nil || lr.err == io.EOF) {
+		lr.err = err
+	}
+}
@@ -0,0 +1,208 @@
+package carve
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// readAllLines collects every line of lr, copied since batches are
+// only valid until the next call.
+func readAllLines(lr *LineReader) ([]string, error) {
+	var got []string
+	for {
+		lines, err := lr.Next()
+		for _, line := range lines {
+			got = append(got, string(line))
+		}
+		if err != nil {
+			return got, err
+		}
+		if len(lines) == 0 {
+			return got, errors.New("Next returned no lines and no error")
+		}
+	}
+}
+
+func TestLineReader(t *testing.T) {
+	inputs := []string{
+		"",
+		"\n",
+		"a",
+		"a\n",
+		"a\nbc",
+		"a\n\nbc\r\n",
+		strings.Repeat("x", 100) + "\nshort\n" + strings.Repeat("y", 37),
+		strings.Repeat("0123456789 abcdef\n", 50),
+	}
+	readers := map[string]func(string) io.Reader{
+		"whole":   func(s string) io.Reader { return strings.NewReader(s) },
+		"onebyte": func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) },
+		"half":    func(s string) io.Reader { return iotest.HalfReader(strings.NewReader(s)) },
+		"eof":     func(s string) io.Reader { return iotest.DataErrReader(strings.NewReader(s)) },
+	}
+	for _, input := range inputs {
+		want := strings.Split(input, "\n")
+		if strings.HasSuffix(input, "\n") || input == "" {
+			want = want[:len(want)-1]
+		}
+		for name, reader := range readers {
+			// Buffers smaller than a line make it straddle reads and
+			// grow the buffer.
+			for _, size := range []int{1, 7, 64, 0} {
+				got, err := readAllLines(NewLineReader(reader(input), size))
+				if err != io.EOF {
+					t.Fatalf("%s/%d %q: error %v", name, size, input, err)
+				}
+				if strings.Join(got, "|") != strings.Join(want, "|") || len(got) != len(want) {
+					t.Fatalf("%s/%d %q: lines %q, want %q", name, size, input, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestLineReaderError(t *testing.T) {
+	failure := errors.New("disk on fire")
+	r := io.MultiReader(strings.NewReader("a\nb\npartial"), iotest.ErrReader(failure))
+	got, err := readAllLines(NewLineReader(r, 4))
+	if err != failure {
+		t.Fatalf("error %v, want %v", err, failure)
+	}
+	if strings.Join(got, "|") != "a|b" {
+		t.Errorf("lines %q, want the complete ones", got)
+	}
+
+	// Lines read along with an error are still returned.
+	got, err = readAllLines(NewLineReader(&dataErrReader{"a\nb\npartial", failure}, 0))
+	if err != failure || strings.Join(got, "|") != "a|b" {
+		t.Errorf("lines %q and error %v, want the complete ones and %v", got, err, failure)
+	}
+
+	lr := NewLineReader(emptyReader{}, 0)
+	if _, err := lr.Next(); err != io.ErrNoProgress {
+		t.Errorf("empty reads: error %v, want %v", err, io.ErrNoProgress)
+	}
+}
+
+// dataErrReader returns its data and its error from the same Read.
+type dataErrReader struct {
+	data string
+	err  error
+}
+
+func (r *dataErrReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	if r.data == "" {
+		return n, r.err
+	}
+	return n, nil
+}
+
+type emptyReader struct{}
+
+func (emptyReader) Read([]byte) (int, error) { return 0, nil }
+
+func TestLineReaderAllocs(t *testing.T) {
+	input := strings.Repeat("2023-01-01T10:00:00Z INFO started\n", 1000)
+	r := strings.NewReader(input)
+	lr := NewLineReader(r, 4096)
+	readAllLines(lr)
+
+	// Once its buffers have grown, reading allocates nothing.
+	allocs := testing.AllocsPerRun(20, func() {
+		r.Reset(input)
+		lr.Reset(r)
+		for {
+			if _, err := lr.Next(); err != nil {
+				break
+			}
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("LineReader allocates %v times per pass", allocs)
+	}
+}
+
+func TestLineReaderLongLines(t *testing.T) {
+	long := strings.Repeat("x", 40)
+	input := "short\n" + long + "\nabcdefghij\n" + long + "y\r\nend\n" + long
+	tests := []struct {
+		policy  LongLinePolicy
+		want    string // lines joined by |, truncated ones marked with a trailing *
+		rejects string
+		err     error
+	}{
+		{LongLineError, "short", "", ErrLineTooLong},
+		{LongLineTruncate, "short|xxxxxxxxxx*|abcdefghij|xxxxxxxxxx*|end|xxxxxxxxxx*", "", io.EOF},
+		{LongLineSkip, "short|abcdefghij|end", "", io.EOF},
+		{LongLineReject, "short|abcdefghij|end", long + "\n" + long + "y\r\n" + long + "\n", io.EOF},
+	}
+	readers := map[string]func(string) io.Reader{
+		"whole":   func(s string) io.Reader { return strings.NewReader(s) },
+		"onebyte": func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) },
+		"half":    func(s string) io.Reader { return iotest.HalfReader(strings.NewReader(s)) },
+	}
+	for _, tt := range tests {
+		for name, reader := range readers {
+			for _, size := range []int{1, 7, 16, 64, 0} {
+				var rejects strings.Builder
+				lr := NewLineReader(reader(input), size).WithOptions(LineOptions{MaxLength: 10, Policy: tt.policy, Reject: &rejects})
+				var got []string
+				var err error
+				for err == nil {
+					var lines [][]byte
+					lines, err = lr.Next()
+					for i, line := range lines {
+						if lr.Truncated(i) {
+							line = append(line, '*')
+						}
+						got = append(got, string(line))
+					}
+				}
+				where := tt.policy.String() + "/" + name + "/" + strconv.Itoa(size)
+				if err != tt.err {
+					t.Fatalf("%s: error %v, want %v", where, err, tt.err)
+				}
+				if strings.Join(got, "|") != tt.want {
+					t.Fatalf("%s: lines %q, want %s", where, got, tt.want)
+				}
+				if rejects.String() != tt.rejects {
+					t.Fatalf("%s: rejected %q, want %q", where, rejects.String(), tt.rejects)
+				}
+				wantLong := 3
+				if tt.policy == LongLineError {
+					wantLong = 1
+				}
+				if lr.LongLines() != wantLong {
+					t.Fatalf("%s: %d long lines, want %d", where, lr.LongLines(), wantLong)
+				}
+				// The buffer grows to hold MaxLength+1 bytes at most.
+				if size > 0 && len(lr.buf) > max(size, 11) {
+					t.Fatalf("%s: buffer grew to %d", where, len(lr.buf))
+				}
+			}
+		}
+	}
+}
+
+func TestLineReaderRejectError(t *testing.T) {
+	failure := errors.New("reject sink full")
+	lr := NewLineReader(strings.NewReader("a\n"+strings.Repeat("x", 20)+"\nb\n"), 0)
+	lr.WithOptions(LineOptions{MaxLength: 10, Policy: LongLineReject, Reject: failingWriter{failure}})
+	got, err := readAllLines(lr)
+	if err != failure {
+		t.Fatalf("error %v, want %v", err, failure)
+	}
+	if strings.Join(got, "|") != "a" {
+		t.Errorf("lines %q, want those before the failure", got)
+	}
+}
+
+type failingWriter struct{ err error }
+
+func (w failingWriter) Write([]byte) (int, error) { return 0, w.err }
@@ -0,0 +1,434 @@
+package carve
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// ============================================================
+// Plan serialization
+// ============================================================
+
+// PlanVersion is the version of the serialized plan format. It changes
+// whenever the scan VM changes in a way that makes older plans scan
+// differently; LoadScanner rejects plans of any other version.
+const PlanVersion = 7
+
+const planMagic = "carve-plan"
+
+// MarshalBinary encodes the scanner's compiled plan, schema and options.
+// The sub-expressions that verifying scans check field content against
+// are stored as source and recompiled by LoadScanner.
+func (s *Scanner) MarshalBinary() ([]byte, error) {
+	e := planEncoder{buf: []byte(planMagic)}
+	e.uint(PlanVersion)
+
+	fields := s.schema.Fields()
+	e.uint(uint64(len(fields)))
+	for _, f := range fields {
+		name, ok := typeNames[f.Type.ID()]
+		if !ok {
+			return nil, fmt.Errorf("carve: cannot marshal column %q of type %s", f.Name, f.Type)
+		}
+		e.string(f.Name)
+		e.string(name)
+		if ts, ok := f.Type.(*arrow.TimestampType); ok {
+			e.uint(uint64(ts.Unit))
+			e.string(ts.TimeZone)
+			e.string(fieldLayout(f))
+		}
+	}
+
+	e.uint(uint64(s.mode))
+	e.bool(s.sparse)
+	e.bool(s.opts.ZeroCopy)
+	e.bool(s.opts.Verify)
+	e.bool(s.opts.Unescape)
+	e.bool(s.opts.TrimCR)
+	e.bool(s.opts.Multiline)
+	e.regexp(s.re)
+	e.uint(uint64(len(s.capCol)))
+	for _, c := range s.capCol {
+		e.int(c)
+	}
+	e.uint(uint64(len(s.warnings)))
+	for _, w := range s.warnings {
+		e.string(w)
+	}
+	e.prog(&s.scanProg)
+	return e.buf, nil
+}
+
+// UnmarshalBinary replaces s with the scanner encoded in data.
+func (s *Scanner) UnmarshalBinary(data []byte) error {
+	d := planDecoder{buf: data}
+	if string(d.bytes(len(planMagic))) != planMagic {
+		return errors.New("carve: not a serialized scan plan")
+	}
+	if v := d.uint(); d.err == nil && v != PlanVersion {
+		return fmt.Errorf("carve: plan version %d does not match this version of carve (%d); recompile the pattern", v, PlanVersion)
+	}
+
+	n := d.count()
+	fields := make([]arrow.Field, 0, n)
+	for i := 0; i < n && d.err == nil; i++ {
+		name, typ := d.string(), d.string()
+		if typ == "timestamp" {
+			opts := TimestampOptions{Unit: arrow.TimeUnit(d.uint()), TimeZone: d.string(), Layout: d.string()}
+			f, err := timestampField(name, opts)
+			if err != nil && d.err == nil {
+				d.err = err
+			}
+			fields = append(fields, f)
+			continue
+		}
+		dt, ok := columnTypes[typ]
+		if !ok {
+			d.fail("unknown column type %q", typ)
+		}
+		fields = append(fields, arrow.Field{Name: name, Type: dt})
+	}
+
+	var t Scanner
+	t.numCols = len(fields)
+	t.mode = Mode(d.uint())
+	t.sparse = d.bool()
+	t.opts.ZeroCopy = d.bool()
+	t.opts.Verify = d.bool()
+	t.opts.Unescape = d.bool()
+	t.opts.TrimCR = d.bool()
+	t.opts.Multiline = d.bool()
+	t.re = d.regexp()
+	t.capCol = make([]int, d.count())
+	for i := range t.capCol {
+		t.capCol[i] = d.col(t.numCols)
+	}
+	t.warnings = make([]string, d.count())
+	for i := range t.warnings {
+		t.warnings[i] = d.string()
+	}
+	t.scanProg = d.prog(t.numCols)
+
+	switch {
+	case d.err != nil:
+	case len(d.buf) > 0:
+		d.fail("%d trailing bytes", len(d.buf))
+	case t.numCols == 0:
+		d.fail("no columns")
+	case t.mode > ModeRegexp:
+		d.fail("unknown mode %d", t.mode)
+	case t.mode == ModeRegexp && t.re == nil:
+		d.fail("regexp mode without a pattern")
+	case t.re != nil && len(t.capCol) != t.re.NumSubexp()+1:
+		d.fail("capture map does not match the pattern")
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	t.schema = arrow.NewSchema(fields, nil)
+	t.scratch = make([][]byte, t.numCols)
+	t.compactPlan()
+	*s = t
+	return nil
+}
+
+// LoadScanner decodes a scanner written by Scanner.MarshalBinary. It
+// fails if the plan was written by a carve with a different PlanVersion.
+func LoadScanner(data []byte) (*Scanner, error) {
+	s := new(Scanner)
+	if err := s.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// typeNames are the names column types are stored under; they are read
+// back through columnTypes.
+var typeNames = map[arrow.Type]string{
+	arrow.BINARY:  "binary",
+	arrow.INT8:    "int8",
+	arrow.INT16:   "int16",
+	arrow.INT32:   "int32",
+	arrow.INT64:   "int64",
+	arrow.UINT8:   "uint8",
+	arrow.UINT16:  "uint16",
+	arrow.UINT32:  "uint32",
+	arrow.UINT64:  "uint64",
+	arrow.FLOAT32: "float32",
+	arrow.FLOAT64: "float64",
+	arrow.BOOL:    "bool",
+	// followed by the unit, time zone and layout
+	arrow.TIMESTAMP: "timestamp",
+}
+
+type planEncoder struct {
+	buf []byte
+}
+
+func (e *planEncoder) uint(v uint64) { e.buf = binary.AppendUvarint(e.buf, v) }
+
+func (e *planEncoder) int(v int) { e.buf = binary.AppendVarint(e.buf, int64(v)) }
+
+func (e *planEncoder) bool(b bool) {
+	if b {
+		e.buf = append(e.buf, 1)
+	} else {
+		e.buf = append(e.buf, 0)
+	}
+}
+
+func (e *planEncoder) string(s string) {
+	e.uint(uint64(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// regexp writes re's source, with a presence flag so an empty pattern
+// and no pattern stay distinct.
+func (e *planEncoder) regexp(re *regexp.Regexp) {
+	e.bool(re != nil)
+	if re != nil {
+		e.string(re.String())
+	}
+}
+
+func (e *planEncoder) set(s *byteSet) {
+	for _, w := range s {
+		e.buf = binary.LittleEndian.AppendUint64(e.buf, w)
+	}
+}
+
+// run writes a whitespace run with a presence flag.
+func (e *planEncoder) run(r *spaceRun) {
+	e.bool(r != nil)
+	if r != nil {
+		e.set(&r.set)
+		e.int(r.min)
+	}
+}
+
+func (e *planEncoder) prog(p *scanProg) {
+	e.string(string(p.prefix))
+	e.string(string(p.suffix))
+	e.bool(p.anchorEnd)
+	e.uint(uint64(len(p.fields)))
+	for i := range p.fields {
+		f := &p.fields[i]
+		e.int(f.col)
+		e.string(string(f.delim))
+		e.int(f.min)
+		e.int(f.max)
+		e.set(&f.class)
+		e.bool(f.hasClass)
+		e.bool(f.byClass)
+		e.bool(f.isLast)
+		e.bool(f.suffixFirst)
+		e.regexp(f.re)
+		e.string(string(f.quotes))
+		e.bool(f.doubled)
+		e.bool(f.enclosed)
+		e.string(string(f.pair))
+		e.run(f.lead)
+		e.run(f.trail)
+		e.uint(uint64(len(f.alts)))
+		for j := range f.alts {
+			e.prog(&f.alts[j])
+		}
+		e.uint(uint64(len(f.cols)))
+		for _, c := range f.cols {
+			e.int(c)
+		}
+	}
+}
+
+// planDecoder reads what planEncoder wrote. The first error sticks and
+// every later read returns a zero value.
+type planDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *planDecoder) fail(format string, args ...any) {
+	if d.err == nil {
+		d.err = fmt.Errorf("carve: corrupt scan plan: "+format, args...)
+	}
+}
+
+func (d *planDecoder) bytes(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.fail("unexpected end of data")
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *planDecoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.fail("bad integer")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *planDecoder) int() int {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.fail("bad integer")
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return int(v)
+}
+
+// count reads a length and checks it against the remaining data, so a
+// corrupt length cannot cause a huge allocation.
+func (d *planDecoder) count() int {
+	v := d.uint()
+	if v > uint64(len(d.buf)) {
+		d.fail("length %d exceeds data", v)
+		return 0
+	}
+	return int(v)
+}
+
+// col reads a column index: -1 or below numCols.
+func (d *planDecoder) col(numCols int) int {
+	c := d.int()
+	if c < -1 || c >= numCols {
+		d.fail("column %d out of range", c)
+		return -1
+	}
+	return c
+}
+
+func (d *planDecoder) bool() bool {
+	b := d.bytes(1)
+	return b != nil && b[0] != 0
+}
+
+func (d *planDecoder) string() string {
+	return string(d.bytes(d.count()))
+}
+
+func (d *planDecoder) regexp() *regexp.Regexp {
+	if !d.bool() {
+		return nil
+	}
+	src := d.string()
+	if d.err != nil {
+		return nil
+	}
+	re, err := regexp.Compile(src)
+	if err != nil {
+		d.fail("%v", err)
+		return nil
+	}
+	return re
+}
+
+func (d *planDecoder) set() byteSet {
+	var s byteSet
+	for i := range s {
+		if b := d.bytes(8); b != nil {
+			s[i] = binary.LittleEndian.Uint64(b)
+		}
+	}
+	return s
+}
+
+func (d *planDecoder) run() *spaceRun {
+	if !d.bool() {
+		return nil
+	}
+	r := &spaceRun{set: d.set(), min: d.int()}
+	if r.min < 0 {
+		d.fail("negative run length")
+	}
+	return r
+}
+
+func (d *planDecoder) prog(numCols int) scanProg {
+	var p scanProg
+	if prefix := d.string(); prefix != "" {
+		p.prefix = []byte(prefix)
+	}
+	if suffix := d.string(); suffix != "" {
+		p.suffix = []byte(suffix)
+	}
+	p.anchorEnd = d.bool()
+	n := d.count()
+	if n > 0 {
+		p.fields = make([]fieldVM, n)
+	}
+	for i := 0; i < n && d.err == nil; i++ {
+		f := &p.fields[i]
+		f.col = d.col(numCols)
+		if delim := d.string(); delim != "" {
+			f.delim = []byte(delim)
+		}
+		f.min = d.int()
+		f.max = d.int()
+		f.class = d.set()
+		f.hasClass = d.bool()
+		f.byClass = d.bool()
+		f.isLast = d.bool()
+		f.suffixFirst = d.bool()
+		f.re = d.regexp()
+		if quotes := d.string(); quotes != "" {
+			f.quotes = []byte(quotes)
+		}
+		f.doubled = d.bool()
+		f.enclosed = d.bool()
+		if pair := d.string(); pair != "" {
+			f.pair = []byte(pair)
+		}
+		f.lead = d.run()
+		f.trail = d.run()
+		if alts := d.count(); alts > 0 {
+			f.alts = make([]scanProg, alts)
+			for j := range f.alts {
+				f.alts[j] = d.prog(numCols)
+			}
+		}
+		if cols := d.count(); cols > 0 {
+			f.cols = make([]int, cols)
+			for j := range f.cols {
+				if f.cols[j] = d.col(numCols); f.cols[j] < 0 {
+					d.fail("branch clears a skip field")
+				}
+			}
+		}
+
+		// The VM relies on every instruction either ending the field
+		// somehow or handing over to a branch. Those without a column
+		// (col -1) are skip fields.
+		switch {
+		case f.alts != nil && i != n-1:
+			d.fail("branch before the end of a sequence")
+		case f.alts == nil && !f.isLast && !f.byClass && !f.enclosed && f.pair == nil && f.lead == nil && len(f.delim) == 0:
+			d.fail("field %d has no terminator", f.col)
+		case (f.doubled || f.enclosed) && f.quotes == nil:
+			d.fail("field %d has quoting without quotes", f.col)
+		case f.pair != nil && (len(f.pair) != 2 || f.isLast || f.byClass):
+			d.fail("field %d has a bad bracket pair", f.col)
+		}
+	}
+	return p
+}
@@ -0,0 +1,186 @@
+package carve
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+func TestScannerMarshalRoundTrip(t *testing.T) {
+	lines := append([]string{
+		"2023-01-01T10:00:00.123Z INFO Application started",
+		"2023-01-01T10:00:00.123Z [main] INFO com.example.App - Application started",
+		`192.168.1.1 - - [01/Jan/2023:10:00:00 +0000] "GET /api/users HTTP/1.1" 200 1234`,
+		"k=12 rest of it",
+		"v=ab rest",
+	}, exampleLines...)
+
+	build := []struct {
+		name string
+		new  func() (*Scanner, error)
+	}{
+		{"compiled", func() (*Scanner, error) { return New(examplePatterns[4]) }},
+		{"branches", func() (*Scanner, error) { return New(`^(?:k=(?P<k>\d+)|v=(?P<v>[a-z]+)) (?P<rest>.*)$`) }},
+		{"projection", func() (*Scanner, error) {
+			s, err := New(`^(?:k=(?P<k>\d+)|v=(?P<v>[a-z]+)) (?P<rest>.*)$`)
+			if err != nil {
+				return nil, err
+			}
+			return s.WithProjection("rest", "k")
+		}},
+		{"whitespace", func() (*Scanner, error) { return New(`^(?P<a>\S+)\s+-\s+(?P<b>\S+) +(?P<c>.*)$`) }},
+		{"partial", func() (*Scanner, error) { return New(`^(?P<a>\w+) (?P<b>.+)\b`) }},
+		{"skip", func() (*Scanner, error) { return New(`^(?P<a>\S+) \S+ (?P<b>\S+) (?P<c>.*)`) }},
+		{"regexp", func() (*Scanner, error) { return New(`^(?:(?P<a>\S+) )+(?P<b>\S+) (?P<c>.*)`) }},
+		{"template", func() (*Scanner, error) { return NewTemplate("{ts} [{thread}] {level} {msg...}") }},
+		{"template skip", func() (*Scanner, error) { return NewTemplate("{ts} {_} {level} {msg...}") }},
+		{"grok", func() (*Scanner, error) { return NewGrok().New(`^%{COMMONAPACHELOG}$`) }},
+		{"typed", func() (*Scanner, error) {
+			s, err := New(`^(?P<status__uint16>\d+) (?P<ok__bool>\w+) (?P<took>\S+) (?P<msg>.*)$`)
+			if err != nil {
+				return nil, err
+			}
+			return s.WithTypes(map[string]string{"took": "float32"})
+		}},
+		{"timestamps", func() (*Scanner, error) {
+			s, err := NewTemplate("{ts__timestamp} {at__epoch_ns} [{clf}] {msg...}")
+			if err != nil {
+				return nil, err
+			}
+			return s.WithTimestamp("clf", TimestampOptions{Layout: "%d/%b/%Y:%H:%M:%S %z", Unit: arrow.Millisecond, TimeZone: "Etc/GMT-2"})
+		}},
+	}
+
+	for _, tt := range build {
+		t.Run(tt.name, func(t *testing.T) {
+			orig, err := tt.new()
+			if err != nil {
+				t.Fatal(err)
+			}
+			orig.WithOptions(Options{ZeroCopy: true, Verify: true})
+
+			data, err := orig.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			loaded, err := LoadScanner(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(orig.Plan(), loaded.Plan()) {
+				t.Fatalf("plan changed:\n%+v\n%+v", orig.Plan(), loaded.Plan())
+			}
+			if !reflect.DeepEqual(orig.Schema(), loaded.Schema()) {
+				t.Fatalf("schema changed: %v, %v", orig.Schema(), loaded.Schema())
+			}
+			if loaded.opts != orig.opts {
+				t.Fatalf("options changed: %+v, %+v", orig.opts, loaded.opts)
+			}
+
+			want := make([][]byte, len(orig.Schema().Fields()))
+			got := make([][]byte, len(want))
+			for _, line := range lines {
+				okWant := orig.Scan([]byte(line), want)
+				okGot := loaded.Scan([]byte(line), got)
+				if okWant != okGot || orig.Reason() != loaded.Reason() || !reflect.DeepEqual(want, got) {
+					t.Fatalf("line %q: loaded scanner returned %v %q (%v), want %v %q (%v)",
+						line, okGot, got, loaded.Reason(), okWant, want, orig.Reason())
+				}
+			}
+		})
+	}
+}
+
+func TestMarshalOptions(t *testing.T) {
+	s, err := NewTemplate("{ts} {level} {msg...}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{ZeroCopy: true, Verify: true, Unescape: true, TrimCR: true, Multiline: true}
+	data, err := s.WithOptions(opts).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadScanner(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.opts != opts {
+		t.Fatalf("options = %+v, want %+v", loaded.opts, opts)
+	}
+}
+
+func TestLoadScannerErrors(t *testing.T) {
+	s, err := New(`^(?P<a>\w+) (?P<b>\d+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	future := []byte(planMagic)
+	future = binary.AppendUvarint(future, PlanVersion+1)
+	future = append(future, data[len(future):]...)
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"empty", nil, "not a serialized scan plan"},
+		{"wrong magic", []byte("not-a-plan at all"), "not a serialized scan plan"},
+		{"other version", future, "does not match this version of carve"},
+		{"truncated", data[:len(data)-3], "corrupt scan plan"},
+		{"trailing bytes", append(bytes.Clone(data), 0), "trailing bytes"},
+	}
+	for _, tt := range tests {
+		_, err := LoadScanner(tt.data)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: LoadScanner error = %v, want %q", tt.name, err, tt.want)
+		}
+	}
+
+	// No prefix of a valid plan may load or panic.
+	for i := range data {
+		if _, err := LoadScanner(data[:i]); err == nil {
+			t.Fatalf("LoadScanner accepted a plan truncated to %d bytes", i)
+		}
+	}
+}
+
+func FuzzLoadScanner(f *testing.F) {
+	for _, pattern := range examplePatterns {
+		s, err := New(pattern)
+		if err != nil {
+			f.Fatal(err)
+		}
+		data, err := s.MarshalBinary()
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		s, err := LoadScanner(data)
+		if err != nil {
+			return
+		}
+		// Whatever loads must be safe to scan with.
+		out := make([][]byte, len(s.Schema().Fields()))
+		for _, line := range exampleLines {
+			s.Scan([]byte(line), out)
+		}
+		s.WithOptions(Options{ZeroCopy: true, Verify: true})
+		for _, line := range exampleLines {
+			s.Scan([]byte(line), out)
+		}
+	})
+}
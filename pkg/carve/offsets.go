@@ -0,0 +1,198 @@
+package carve
+
+import (
+	"fmt"
+	"math/bits"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// ============================================================
+// Offset output
+// ============================================================
+
+// ScanOffsets is Scan reporting where each field lies in line instead of
+// slicing it: field i is line[starts[i]:ends[i]], and a field Scan would
+// report as nil has both set to -1. Quoted fields are reported as they
+// appear in the line, escapes included, whatever Options.Unescape says,
+// and ZeroCopy does not apply. It returns false for the same lines Scan
+// does, and does not allocate. Lines must be shorter than 2 GiB.
+func (s *Scanner) ScanOffsets(line []byte, starts, ends []int32) bool {
+	if len(starts) < s.numCols || len(ends) < s.numCols {
+		s.reason = RejectShortOutput
+		return false
+	}
+	opts := s.startInPlace()
+	ok := s.Scan(line, s.scratch)
+	if ok {
+		for c := range s.spans {
+			starts[c], ends[c] = s.span(c)
+		}
+	}
+	s.endInPlace(opts)
+	return ok
+}
+
+// ColumnOffsets holds the field positions ScanBatch found in a buffer,
+// one array per column: in row r, column c is buf[Starts[c][r]:Ends[c][r]],
+// or absent if both are -1. Lines holds the index of the line each row
+// was scanned from, as rejected lines leave no row.
+type ColumnOffsets struct {
+	Starts [][]int32
+	Ends   [][]int32
+	Lines  []int32
+}
+
+// Reset empties c and sizes it for n columns, keeping its capacity.
+func (c *ColumnOffsets) Reset(n int) {
+	for len(c.Starts) < n {
+		c.Starts = append(c.Starts, nil)
+		c.Ends = append(c.Ends, nil)
+	}
+	c.Starts, c.Ends = c.Starts[:n], c.Ends[:n]
+	for i := range c.Starts {
+		c.Starts[i] = c.Starts[i][:0]
+		c.Ends[i] = c.Ends[i][:0]
+	}
+	c.Lines = c.Lines[:0]
+}
+
+// Rows returns the number of rows in c.
+func (c *ColumnOffsets) Rows() int { return len(c.Lines) }
+
+// ScanBatch scans the lines of buf into cols, replacing what it held, and
+// returns the number of rows. Line i is buf[lineOffsets[i]:lineOffsets[i+1]]
+// less a trailing \n, so for a newline-separated buffer the offsets are
+// where each line starts followed by len(buf); LineOffsets computes them.
+// Fields are positioned as by ScanOffsets but relative to buf, and lines
+// Scan would reject leave no row; Writer.WriteOffsets builds columns of
+// them. Once cols has grown to the batch size, ScanBatch does not
+// allocate. buf must be shorter than 2 GiB.
+//
+// Unless it verifies, a scanner whose fields end at delimiters, closing
+// brackets or closing quotes indexes them over all of buf first; see
+// structural.go.
+func (s *Scanner) ScanBatch(buf []byte, lineOffsets []int32, cols *ColumnOffsets) int {
+	cols.Reset(s.numCols)
+	if tables, ok := s.indexTables(); ok {
+		s.index = buildIndex(&tables, buf, s.index)
+		for i := 0; i+1 < len(lineOffsets); i++ {
+			start, end := lineBounds(buf, lineOffsets, i)
+			if s.opts.TrimCR && end > start && buf[end-1] == '\r' {
+				end--
+			}
+			s.scanIndexed(buf, s.index, start, end, cols)
+			cols.Lines = append(cols.Lines, int32(i))
+		}
+		return len(cols.Lines)
+	}
+
+	opts := s.startInPlace()
+	for i := 0; i+1 < len(lineOffsets); i++ {
+		start, end := lineBounds(buf, lineOffsets, i)
+		if !s.Scan(buf[start:end], s.scratch) {
+			continue
+		}
+		for c := range s.spans {
+			from, to := s.span(c)
+			if from >= 0 {
+				from, to = from+int32(start), to+int32(start)
+			}
+			cols.Starts[c] = append(cols.Starts[c], from)
+			cols.Ends[c] = append(cols.Ends[c], to)
+		}
+		cols.Lines = append(cols.Lines, int32(i))
+	}
+	s.endInPlace(opts)
+	return len(cols.Lines)
+}
+
+// WriteOffsets appends the rows ScanBatch found in buf to the batch,
+// each value read from buf at its offsets, and returns the batch once it
+// holds maxRows rows. All the rows of cols are kept, so a batch can run
+// past maxRows by fewer rows than cols has.
+func (w *Writer) WriteOffsets(buf []byte, cols *ColumnOffsets) (arrow.Record, error) {
+	if len(cols.Starts) != len(w.builders) {
+		return nil, fmt.Errorf("carve: offsets of %d columns for a schema of %d", len(cols.Starts), len(w.builders))
+	}
+	for c, b := range w.builders {
+		vals, valid := w.tempColVals[c][:0], w.tempValids[c][:0]
+		for r, start := range cols.Starts[c] {
+			var v []byte
+			if start >= 0 {
+				v = buf[start:cols.Ends[c][r]]
+			}
+			vals = append(vals, v)
+			valid = append(valid, v != nil)
+		}
+		appendValues(b, vals, valid)
+		w.tempColVals[c], w.tempValids[c] = vals, valid
+	}
+	w.rows += cols.Rows()
+	if w.rows >= w.maxRows {
+		return w.Flush()
+	}
+	return nil, nil
+}
+
+// lineBounds returns where line i of buf starts and ends, less its \n.
+func lineBounds(buf []byte, lineOffsets []int32, i int) (start, end int) {
+	start, end = int(lineOffsets[i]), int(lineOffsets[i+1])
+	if end > start && buf[end-1] == '\n' {
+		end--
+	}
+	return start, end
+}
+
+// LineOffsets appends the line offsets of a newline-separated buffer to
+// dst, in the form ScanBatch takes: the start of every line, then
+// len(buf). A final line without a newline counts as a line. The
+// newlines are found with a structural index, as ScanBatch finds
+// delimiters, 16 KiB of buf at a time.
+func LineOffsets(dst []int32, buf []byte) []int32 {
+	if len(buf) == 0 {
+		return dst
+	}
+	dst = append(dst, 0)
+	var words [256]uint64
+	last := len(buf) - 1 // a newline there starts no line
+	for base := 0; base < last; base += 64 * len(words) {
+		index := buildIndex(&newlineTables, buf[base:min(base+64*len(words), last)], words[:0])
+		for k, word := range index {
+			for ; word != 0; word &= word - 1 {
+				dst = append(dst, int32(base+k<<6+bits.TrailingZeros64(word)+1))
+			}
+		}
+	}
+	return append(dst, int32(len(buf)))
+}
+
+var newlineTables, _ = structuralTables([]byte{'\n'})
+
+// startInPlace makes Scan note where in the line it finds each field,
+// for ScanOffsets and ScanBatch, until endInPlace restores opts. Values
+// are neither copied nor unescaped meanwhile, as only their positions
+// are wanted.
+func (s *Scanner) startInPlace() (opts Options) {
+	opts = s.opts
+	s.opts.ZeroCopy, s.opts.Unescape, s.opts.Multiline = true, false, false
+	if cap(s.spans) < s.numCols {
+		s.spans = make([][2]int32, s.numCols)
+	}
+	s.spans = s.spans[:s.numCols]
+	return opts
+}
+
+func (s *Scanner) endInPlace(opts Options) {
+	s.opts = opts
+	s.spans = s.spans[:0]
+}
+
+// span returns where column c of the line just scanned in place lies in
+// it, or -1, -1 if the field is absent.
+func (s *Scanner) span(c int) (start, end int32) {
+	if s.scratch[c] == nil {
+		return -1, -1
+	}
+	return s.spans[c][0], s.spans[c][1]
+}
@@ -0,0 +1,248 @@
+package carve
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestScanOffsets(t *testing.T) {
+	tests := []struct {
+		pattern string
+		line    string
+	}{
+		{`^(?P<ts>[^ ]+) (?P<level>\w+) (?P<msg>.+)$`, "2023-01-01T10:00:00Z INFO started"},
+		{`^(?P<host>[^: ]+)(?::(?P<port>\d+))? (?P<msg>.*)$`, "db1 connection reset"},
+		{`^(?P<ip>\S+) \S+ (?P<user>\S+) \[(?P<ts>[^\]]+)\] "(?P<req>[^"]*)"`, `::1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200`},
+		{`^(?:(?P<kv>\w+=\w+) )+(?P<msg>.*)$`, "a=1 b=2 done"},
+		{`^(?P<a>\w+) (?P<b>\w*)$`, "x "},
+	}
+	for _, tt := range tests {
+		s, err := New(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.WithOptions(Options{ZeroCopy: true, Verify: true})
+		n := len(s.Schema().Fields())
+		want := make([][]byte, n)
+		if !s.Scan([]byte(tt.line), want) {
+			t.Fatalf("%s: Scan rejected %q: %v", tt.pattern, tt.line, s.Reason())
+		}
+
+		s.WithOptions(Options{Verify: true})
+		starts, ends := make([]int32, n), make([]int32, n)
+		if !s.ScanOffsets([]byte(tt.line), starts, ends) {
+			t.Fatalf("%s: ScanOffsets rejected %q: %v", tt.pattern, tt.line, s.Reason())
+		}
+		for i := range want {
+			switch {
+			case want[i] == nil && (starts[i] != -1 || ends[i] != -1):
+				t.Errorf("%s: field %d at [%d, %d), want absent", tt.pattern, i, starts[i], ends[i])
+			case want[i] != nil && tt.line[starts[i]:ends[i]] != string(want[i]):
+				t.Errorf("%s: field %d at [%d, %d) is %q, want %q",
+					tt.pattern, i, starts[i], ends[i], tt.line[starts[i]:ends[i]], want[i])
+			}
+		}
+	}
+}
+
+func TestScanOffsetsQuoted(t *testing.T) {
+	s, err := New(`^(?P<a>\w+) (?P<q>"(?:[^"\\]|\\.)*") (?P<b>\w+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{Verify: true, Unescape: true})
+	line := []byte(`x "say \"hi\"" y`)
+	starts, ends := make([]int32, 3), make([]int32, 3)
+	if !s.ScanOffsets(line, starts, ends) {
+		t.Fatalf("ScanOffsets rejected line: %v", s.Reason())
+	}
+	if got := string(line[starts[1]:ends[1]]); got != `"say \"hi\""` {
+		t.Errorf("q = %s", got)
+	}
+
+	// The scanner's options are left alone.
+	out := make([][]byte, 3)
+	if !s.Scan(line, out) || string(out[1]) != `say "hi"` {
+		t.Errorf("Scan = %q", out)
+	}
+}
+
+func TestScanOffsetsRejects(t *testing.T) {
+	s, err := New(`^(?P<a>\w+) (?P<b>\d+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{Verify: true})
+	starts, ends := make([]int32, 2), make([]int32, 2)
+	if s.ScanOffsets([]byte("x y"), starts, ends) || s.Reason() != RejectClass {
+		t.Errorf("reason %v, want %v", s.Reason(), RejectClass)
+	}
+	if s.ScanOffsets([]byte("x 1"), starts, ends[:1]) || s.Reason() != RejectShortOutput {
+		t.Errorf("reason %v, want %v", s.Reason(), RejectShortOutput)
+	}
+}
+
+func TestScanOffsetsAllocs(t *testing.T) {
+	s, err := New(`^(?P<user>\S+) +(?P<pid>\d+) +(?P<cmd>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line := []byte("www-data   4242   nginx: worker process")
+	starts, ends := make([]int32, 3), make([]int32, 3)
+	for _, verify := range []bool{false, true} {
+		s.WithOptions(Options{Verify: verify})
+		allocs := testing.AllocsPerRun(100, func() {
+			s.ScanOffsets(line, starts, ends)
+		})
+		if allocs != 0 {
+			t.Fatalf("verify=%v: ScanOffsets allocates %v times per line", verify, allocs)
+		}
+	}
+}
+
+func TestScanBatch(t *testing.T) {
+	s, err := New(`^(?P<host>[^: ]+)(?::(?P<port>\d+))? (?P<level>[A-Z]+) (?P<msg>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{Verify: true, TrimCR: true})
+	lines := []string{
+		"db1:5432 WARN connection reset",
+		"not a log line",
+		"web INFO started\r",
+		"",
+		"cache:6379 ERROR out of memory",
+	}
+	buf := []byte(strings.Join(lines, "\n") + "\n")
+	offsets := LineOffsets(nil, buf)
+	if len(offsets) != len(lines)+1 {
+		t.Fatalf("LineOffsets = %v", offsets)
+	}
+
+	var cols ColumnOffsets
+	if n := s.ScanBatch(buf, offsets, &cols); n != 3 {
+		t.Fatalf("ScanBatch = %d rows, want 3", n)
+	}
+	if want := []int32{0, 2, 4}; !reflect.DeepEqual(cols.Lines, want) {
+		t.Fatalf("Lines = %v, want %v", cols.Lines, want)
+	}
+	starts, ends := make([]int32, 4), make([]int32, 4)
+	for r, i := range cols.Lines {
+		s.ScanOffsets([]byte(lines[i]), starts, ends)
+		for c := range starts {
+			want := "<absent>"
+			if starts[c] >= 0 {
+				want = lines[i][starts[c]:ends[c]]
+			}
+			got := "<absent>"
+			if cols.Starts[c][r] >= 0 {
+				got = string(buf[cols.Starts[c][r]:cols.Ends[c][r]])
+			}
+			if got != want {
+				t.Errorf("line %d column %d = %q, want %q", i, c, got, want)
+			}
+		}
+	}
+
+	// Reusing cols replaces its rows and, once grown, allocates nothing.
+	allocs := testing.AllocsPerRun(100, func() {
+		s.ScanBatch(buf, offsets, &cols)
+	})
+	if allocs != 0 {
+		t.Fatalf("ScanBatch allocates %v times per batch", allocs)
+	}
+	if cols.Rows() != 3 {
+		t.Fatalf("Rows = %d after reuse", cols.Rows())
+	}
+}
+
+func TestWriteOffsets(t *testing.T) {
+	s, err := New(`^(?P<host>[^: ]+)(?::(?P<port__int32>\d+))? (?P<level>[A-Z]+) (?P<msg>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{ZeroCopy: true, Verify: true, TrimCR: true})
+	lines := []string{
+		"db1:5432 WARN connection reset",
+		"not a log line",
+		"web INFO started\r",
+		"cache:x ERROR out of memory",
+	}
+	buf := []byte(strings.Join(lines, "\n"))
+	var cols ColumnOffsets
+	s.ScanBatch(buf, LineOffsets(nil, buf), &cols)
+
+	// The same rows as WriteLinesSIMD makes of the lines.
+	w := NewWriter(s.Schema(), memory.DefaultAllocator, 0)
+	if rec, err := w.WriteOffsets(buf, &cols); rec != nil || err != nil {
+		t.Fatalf("WriteOffsets = %v, %v before the batch is full", rec, err)
+	}
+	rec, _ := w.Flush()
+	defer rec.Release()
+	var byLine [][]byte
+	for _, line := range lines {
+		byLine = append(byLine, []byte(line))
+	}
+	want, _ := NewWriter(s.Schema(), memory.DefaultAllocator, 2).WriteLinesSIMD(byLine, s)
+	defer want.Release()
+	if rec.NumRows() != 2 || !array.RecordEqual(rec, want) {
+		t.Fatalf("WriteOffsets record %v, want %v", rec, want)
+	}
+
+	// A full batch is returned.
+	w = NewWriter(s.Schema(), memory.DefaultAllocator, 2)
+	if rec, err := w.WriteOffsets(buf, &cols); rec == nil || err != nil {
+		t.Fatalf("WriteOffsets = %v, %v for a full batch", rec, err)
+	} else {
+		rec.Release()
+	}
+	if _, err := NewWriter(s.Schema(), memory.DefaultAllocator, 0).WriteOffsets(buf, &ColumnOffsets{}); err == nil {
+		t.Error("WriteOffsets took offsets of no columns")
+	}
+}
+
+func TestLineOffsets(t *testing.T) {
+	tests := []struct {
+		buf  string
+		want []int32
+	}{
+		{"", nil},
+		{"a", []int32{0, 1}},
+		{"a\n", []int32{0, 2}},
+		{"a\nbc", []int32{0, 2, 4}},
+		{"a\n\nbc\n", []int32{0, 2, 3, 6}},
+	}
+	for _, tt := range tests {
+		if got := LineOffsets(nil, []byte(tt.buf)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("LineOffsets(%q) = %v, want %v", tt.buf, got, tt.want)
+		}
+	}
+	// Buffers longer than the index LineOffsets builds at a time, with
+	// newlines at the edges of its blocks.
+	rng := rand.New(rand.NewSource(1))
+	for _, n := range []int{64, 16383, 16384, 16385, 40000} {
+		buf := make([]byte, n)
+		for i := range buf {
+			if rng.Intn(8) == 0 || i%64 == 63 || i%16384 == 0 {
+				buf[i] = '\n'
+			} else {
+				buf[i] = 'x'
+			}
+		}
+		want := []int32{0}
+		for i, c := range buf[:n-1] {
+			if c == '\n' {
+				want = append(want, int32(i+1))
+			}
+		}
+		want = append(want, int32(n))
+		if got := LineOffsets([]int32{7}, buf); !reflect.DeepEqual(got, append([]int32{7}, want...)) {
+			t.Errorf("LineOffsets of %d bytes differs", n)
+		}
+	}
+}
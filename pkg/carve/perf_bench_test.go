@@ -1,9 +1,13 @@
 package carve
 
 import (
+	"bytes"
+	"fmt"
 	"runtime"
 	"testing"
 
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
 	"github.com/apache/arrow-go/v18/arrow/memory"
 )
 
@@ -31,6 +35,91 @@ func BenchmarkScannerScan(b *testing.B) {
 	}
 }
 
+func BenchmarkScannerScanBatch(b *testing.B) {
+	scanner, _ := NewExtractor(benchmarkPattern)
+	buf := bytes.Join(benchmarkLines(), []byte("\n"))
+	offsets := LineOffsets(nil, buf)
+	var cols ColumnOffsets
+
+	for _, indexed := range []bool{true, false} {
+		name := "indexed"
+		if !indexed {
+			name = "vm"
+		}
+		b.Run(name, func(b *testing.B) {
+			scanner.noIndex = !indexed
+			b.ReportAllocs()
+			b.SetBytes(int64(len(buf)))
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				scanner.ScanBatch(buf, offsets, &cols)
+			}
+		})
+	}
+}
+
+func BenchmarkLineOffsets(b *testing.B) {
+	buf := bytes.Join(benchmarkLines(), []byte("\n"))
+	offsets := LineOffsets(nil, buf)
+	b.ReportAllocs()
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		offsets = LineOffsets(offsets[:0], buf)
+	}
+}
+
+func BenchmarkStructuralIndex(b *testing.B) {
+	buf := bytes.Join(benchmarkLines(), []byte("\n"))
+	tables, _ := structuralTables([]byte(" \"]"))
+	index := make([]uint64, len(buf)/64)
+	buf = buf[:len(index)*64]
+
+	b.Run("kernel", func(b *testing.B) {
+		b.SetBytes(int64(len(buf)))
+		for i := 0; i < b.N; i++ {
+			indexBlocks(&tables, buf, index)
+		}
+	})
+	b.Run("generic", func(b *testing.B) {
+		b.SetBytes(int64(len(buf)))
+		for i := 0; i < b.N; i++ {
+			indexBlocksGeneric(&tables, buf, index)
+		}
+	})
+}
+
+func BenchmarkTimestampParse(b *testing.B) {
+	vals := make([][]byte, 1024)
+	for i := range vals {
+		vals[i] = []byte(fmt.Sprintf("2023-01-01 10:%02d:%02d.%06d+02:00", i/60%60, i%60, i*997))
+	}
+	layouts := []struct{ name, layout string }{
+		{"iso8601", ""},
+		{"time.Parse", "2006-01-02 15:04:05.999999999Z07:00"},
+	}
+	for _, l := range layouts {
+		b.Run(l.name, func(b *testing.B) {
+			f, err := timestampField("ts", TimestampOptions{Layout: l.layout, Unit: arrow.Microsecond})
+			if err != nil {
+				b.Fatal(err)
+			}
+			builder := newColumnBuilder(memory.DefaultAllocator, f)
+			defer builder.Release()
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				appendValues(builder, vals, nil)
+				if i%64 == 63 {
+					builder.NewArray().Release()
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*len(vals)), "ns/value")
+		})
+	}
+}
+
 func BenchmarkWriterWriteLinesSIMD(b *testing.B) {
 	ext, _ := NewExtractor(benchmarkPattern)
 	scanner := ext.Scanner(Options{ZeroCopy: true})
@@ -65,7 +154,7 @@ func BenchmarkFlush(b *testing.B) {
 		scanner.Scan(line, writer.scratch)
 		for i := range writer.scratch {
 			v := writer.scratch[i]
-			writer.builders[i].Append(v)
+			writer.builders[i].(*array.BinaryBuilder).Append(v)
 		}
 	}
 	writer.rows = len(lines)
@@ -90,7 +179,7 @@ func BenchmarkFlush(b *testing.B) {
 			scanner.Scan(line, writer.scratch)
 			for i := range writer.scratch {
 				v := writer.scratch[i]
-				writer.builders[i].Append(v)
+				writer.builders[i].(*array.BinaryBuilder).Append(v)
 			}
 		}
 		writer.rows = len(lines)
@@ -0,0 +1,705 @@
+package carve
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ============================================================
+// Scan plan
+// ============================================================
+
+// maxScanPaths bounds how many linear paths optional groups and
+// alternations may expand into. Constructs that would exceed it are left
+// unexpanded.
+const maxScanPaths = 64
+
+// fieldVM is one instruction of the scan plan: a field runs from the
+// cursor up to the first occurrence of delim, which is then consumed, and
+// is written to out[col]. min is the shortest content the capture's
+// expression can match.
+//
+// When the capture is a repeated character class (\d+, [^ ]*, \w{2,4})
+// its class is kept for verification. A field with no literal after it
+// ends at the first byte outside the class instead (byClass), which is
+// how two adjacent captures are told apart.
+//
+// The last field (isLast) runs to the end of the line, less the program's
+// suffix. If it cannot contain the suffix (suffixFirst) it ends at the
+// suffix's first occurrence.
+//
+// Captures whose content is not a class run keep an anchored regexp of
+// their expression (re) so verifying scans can still check them.
+//
+// A quoted field (quotes != nil) ends at its first unescaped quote rather
+// than at the first occurrence of its delimiter, which then has to follow
+// the quote. Enclosed fields include their quotes; see quoteEnd.
+//
+// A separator may hold whitespace runs around its literal: lead before
+// it and trail after it. A field with a lead run ends at the first byte of
+// the run, so ` +` and `\s+` separate padded columns, and delim may then
+// be empty.
+//
+// A pair field (pair != nil) is enclosed in brackets. The literal before
+// it ends with the opening bracket; the field runs to the first closing
+// one, which is consumed, and the delimiter, possibly empty, follows it.
+//
+// A skip instruction (col < 0) is a field for an unnamed group or for
+// text the pattern matches without capturing, such as the \S+ in
+// `^\S+ (?P<user>\S+)`. It advances the cursor like any other field but
+// writes no column.
+//
+// A branch instruction (alts != nil) ends its program: each alternative
+// is the rest of the line for one way through an optional group or
+// alternation, and the first one that verifies is taken. cols lists the
+// columns the alternatives write so a failed attempt can be undone.
+type fieldVM struct {
+	col         int
+	delim       []byte
+	min         int
+	max         int
+	class       byteSet
+	hasClass    bool
+	byClass     bool
+	isLast      bool
+	suffixFirst bool
+	re          *regexp.Regexp
+	quotes      []byte
+	doubled     bool
+	enclosed    bool
+	pair        []byte
+	lead        *spaceRun
+	trail       *spaceRun
+	alts        []scanProg
+	cols        []int
+}
+
+// conforms reports whether every byte of b is in the field's class and b
+// is no longer than the class allows.
+func (f *fieldVM) conforms(b []byte) bool {
+	if f.max >= 0 && len(b) > f.max {
+		return false
+	}
+	return f.class.span(b, -1) == len(b)
+}
+
+// same reports whether two linear instructions behave identically.
+func (f *fieldVM) same(g *fieldVM) bool {
+	return f.col == g.col && bytes.Equal(f.delim, g.delim) &&
+		f.min == g.min && f.max == g.max &&
+		f.class == g.class && f.hasClass == g.hasClass &&
+		f.byClass == g.byClass && f.isLast == g.isLast && f.suffixFirst == g.suffixFirst &&
+		(f.re == nil) == (g.re == nil) && (f.re == nil || f.re.String() == g.re.String()) &&
+		bytes.Equal(f.quotes, g.quotes) && f.doubled == g.doubled && f.enclosed == g.enclosed &&
+		bytes.Equal(f.pair, g.pair) && sameRun(f.lead, g.lead) && sameRun(f.trail, g.trail) &&
+		f.alts == nil && g.alts == nil
+}
+
+// scanProg is a compiled sequence: a literal the line starts with, the
+// field instructions, and the literal that follows the last field.
+type scanProg struct {
+	prefix    []byte
+	suffix    []byte
+	anchorEnd bool
+	fields    []fieldVM
+}
+
+// Mode reports which engine a Scanner runs.
+type Mode uint8
+
+const (
+	// ModeCompiled: the scan plan is exact for the pattern; with
+	// Options.Verify, Scan accepts the same lines and produces the same
+	// captures as regexp.
+	ModeCompiled Mode = iota
+	// ModePartial: the scan plan is used, but some constructs are only
+	// approximated. Plan warnings say which.
+	ModePartial
+	// ModeRegexp: the pattern cannot be planned and Scan falls back to
+	// regexp.FindSubmatchIndex.
+	ModeRegexp
+)
+
+func (m Mode) String() string {
+	switch m {
+	case ModeCompiled:
+		return "compiled"
+	case ModePartial:
+		return "partial"
+	case ModeRegexp:
+		return "regexp"
+	default:
+		return "unknown"
+	}
+}
+
+// scanPlan is the compiled form of a pattern.
+type scanPlan struct {
+	scanProg
+	// sparse is set when some named group is not written on every path,
+	// so Scan must clear out before running the program.
+	sparse bool
+	// capCol maps regexp capture indexes to columns (-1 for unnamed).
+	capCol   []int
+	mode     Mode
+	warnings []string
+}
+
+// planner collects what compiling a pattern had to give up on. Fatal
+// issues make the plan unusable; the rest downgrade it to ModePartial.
+type planner struct {
+	names    []string
+	fatal    bool
+	warnings []string
+	// err reports a literal the scan plan would match wrongly. New
+	// fails with it unless the plan is unusable anyway.
+	err error
+	// vms caches captureVM by capture, which every path through the
+	// pattern would otherwise compile again.
+	vms map[*syntax.Regexp]fieldVM
+}
+
+// literal checks that a literal the plan uses as a prefix or delimiter
+// matches exactly its UTF-8 encoding. Case-insensitive literals keep
+// their node only when expand could not spell them out; the pattern then
+// falls back to regexp.
+func (pl *planner) literal(e element) {
+	switch {
+	case pl.err != nil:
+	case e.node != nil:
+		pl.warn(true, "case-insensitive literal %#q has too many spellings to be represented in the scan plan", e.node)
+	case bytes.Contains(e.lit, []byte(string(utf8.RuneError))):
+		pl.err = fmt.Errorf("carve: literal %q contains U+FFFD, which also matches invalid UTF-8 and "+
+			"cannot be represented in the scan plan", e.lit)
+	}
+}
+
+// unsupported records a construct the plan cannot represent at the end
+// of fields, whose content is nodes.
+func (pl *planner) unsupported(fields []fieldVM, nodes []*syntax.Regexp, n *syntax.Regexp) {
+	if len(fields) == 0 {
+		pl.warn(true, "%#q before the first field is not supported", n)
+	} else {
+		i := len(fields) - 1
+		pl.warn(true, "%#q after field %s is not supported", n, pl.label(&fields[i], nodes[i]))
+	}
+}
+
+// label names a field in warnings: by its quoted name, or by the
+// expression a skip field matches.
+func (pl *planner) label(f *fieldVM, node *syntax.Regexp) string {
+	if f.col < 0 {
+		return fmt.Sprintf("%#q", node)
+	}
+	return fmt.Sprintf("%q", pl.names[f.col])
+}
+
+func (pl *planner) warn(fatal bool, format string, args ...any) {
+	pl.fatal = pl.fatal || fatal
+	msg := fmt.Sprintf(format, args...)
+	for _, w := range pl.warnings {
+		if w == msg {
+			return
+		}
+	}
+	pl.warnings = append(pl.warnings, msg)
+}
+
+func buildScanPlan(re *regexp.Regexp, numFields int) (scanPlan, error) {
+	ast, err := syntax.Parse(re.String(), syntax.Perl)
+	if err != nil {
+		return scanPlan{}, err
+	}
+
+	// Capture indexes map to schema columns in named-group order.
+	capCol := make([]int, re.NumSubexp()+1)
+	pl := &planner{}
+	for i, name := range re.SubexpNames() {
+		capCol[i] = -1
+		if i > 0 && name != "" {
+			capCol[i] = len(pl.names)
+			pl.names = append(pl.names, name)
+		}
+	}
+
+	paths := expand(ast, capCol)
+	progs := make([]scanProg, len(paths))
+	placed := make([]bool, numFields)
+	sparse := false
+	for i, path := range paths {
+		var complete bool
+		progs[i], complete = pl.compilePath(path)
+		if !complete || countCols(&progs[i]) < numFields {
+			sparse = true
+		}
+		collectCols(&progs[i], func(c int) { placed[c] = true })
+	}
+	for col, ok := range placed {
+		if !ok {
+			pl.warn(true, "field %q is inside a repeated or nested group", pl.names[col])
+		}
+	}
+	// A plan that falls back to regexp never matches the literal itself.
+	if pl.err != nil && !pl.fatal {
+		return scanPlan{}, pl.err
+	}
+
+	plan := scanPlan{
+		scanProg: factor(progs),
+		sparse:   sparse,
+		capCol:   capCol,
+		mode:     ModeCompiled,
+		warnings: pl.warnings,
+	}
+	switch {
+	case pl.fatal:
+		plan.mode = ModeRegexp
+	case len(pl.warnings) > 0:
+		plan.mode = ModePartial
+	}
+	return plan, nil
+}
+
+// elemKind classifies the pieces of one linear path through a pattern.
+type elemKind uint8
+
+const (
+	elemLiteral elemKind = iota
+	elemCapture
+	elemBegin
+	elemEnd
+	elemAssert // zero-width assertion the plan ignores
+	elemSpace  // whitespace run, kept in a field separator
+	elemSkip   // text matched without a column, compiled to a skip field
+	elemOther  // anything the plan cannot represent
+)
+
+type element struct {
+	kind elemKind
+	lit  []byte
+	node *syntax.Regexp
+	col  int
+	run  *spaceRun
+}
+
+// expand lowers n into the linear element sequences it can match, in the
+// order the regexp engine would prefer them. Optional groups and
+// alternations multiply the paths; named captures are kept whole.
+func expand(n *syntax.Regexp, capCol []int) [][]element {
+	switch n.Op {
+	case syntax.OpConcat:
+		paths := [][]element{nil}
+		for _, sub := range n.Sub {
+			paths = cross(paths, expand(sub, capCol), sub, capCol)
+		}
+		return paths
+	case syntax.OpLiteral:
+		if n.Flags&syntax.FoldCase == 0 {
+			return [][]element{{{kind: elemLiteral, lit: literalBytes(n)}}}
+		}
+		// A case-insensitive literal is an alternation of its spellings.
+		variants := foldVariants(n.Rune)
+		if variants == nil {
+			return [][]element{{{kind: elemLiteral, lit: literalBytes(n), node: n}}}
+		}
+		paths := make([][]element, len(variants))
+		for i, v := range variants {
+			paths[i] = []element{{kind: elemLiteral, lit: v}}
+		}
+		return paths
+	case syntax.OpBeginText, syntax.OpBeginLine:
+		return [][]element{{{kind: elemBegin}}}
+	case syntax.OpEndText, syntax.OpEndLine:
+		return [][]element{{{kind: elemEnd}}}
+	case syntax.OpEmptyMatch:
+		return [][]element{nil}
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return [][]element{{{kind: elemAssert, node: n}}}
+	case syntax.OpCapture:
+		if col := capCol[n.Cap]; col >= 0 {
+			return [][]element{{{kind: elemCapture, node: n.Sub[0], col: col}}}
+		}
+		if structured(n.Sub[0]) {
+			// an unnamed group used only for grouping
+			return expand(n.Sub[0], capCol)
+		}
+		n = n.Sub[0]
+	case syntax.OpPlus, syntax.OpStar, syntax.OpRepeat:
+		if run, ok := spaceRunOf(n); ok {
+			return [][]element{{{kind: elemSpace, node: n, run: run}}}
+		}
+	case syntax.OpQuest:
+		if structured(n.Sub[0]) {
+			present := expand(n.Sub[0], capCol)
+			if len(present)+1 <= maxScanPaths {
+				if n.Flags&syntax.NonGreedy != 0 {
+					return append([][]element{nil}, present...)
+				}
+				return append(present, nil)
+			}
+		}
+	case syntax.OpAlternate:
+		if structured(n) {
+			var paths [][]element
+			for _, sub := range n.Sub {
+				paths = append(paths, expand(sub, capCol)...)
+			}
+			if len(paths) <= maxScanPaths {
+				return paths
+			}
+		}
+	}
+	return [][]element{{opaque(n, capCol)}}
+}
+
+// opaque is the element for a node expand does not look into: a skip
+// field unless the node holds a named capture the plan would lose.
+func opaque(n *syntax.Regexp, capCol []int) element {
+	if named(n, capCol) {
+		return element{kind: elemOther, node: n}
+	}
+	return element{kind: elemSkip, node: n}
+}
+
+// named reports whether n contains a named capture.
+func named(n *syntax.Regexp, capCol []int) bool {
+	if n.Op == syntax.OpCapture && capCol[n.Cap] >= 0 {
+		return true
+	}
+	for _, sub := range n.Sub {
+		if named(sub, capCol) {
+			return true
+		}
+	}
+	return false
+}
+
+// cross concatenates every path in a with every path in b. If the result
+// would be too large, n is kept as a single opaque element instead.
+func cross(a, b [][]element, n *syntax.Regexp, capCol []int) [][]element {
+	if len(a)*len(b) > maxScanPaths {
+		b = [][]element{{opaque(n, capCol)}}
+	}
+	out := make([][]element, 0, len(a)*len(b))
+	for _, pa := range a {
+		for _, pb := range b {
+			path := make([]element, 0, len(pa)+len(pb))
+			path = append(append(path, pa...), pb...)
+			out = append(out, path)
+		}
+	}
+	return out
+}
+
+// structured reports whether n contains captures or literals, i.e.
+// whether expanding it can give the plan anything to work with.
+func structured(n *syntax.Regexp) bool {
+	switch n.Op {
+	case syntax.OpCapture, syntax.OpLiteral:
+		return true
+	}
+	for _, sub := range n.Sub {
+		if structured(sub) {
+			return true
+		}
+	}
+	return false
+}
+
+// compilePath turns one linear path into a program. It reports false if
+// a field could not be terminated and the program was cut short.
+func (pl *planner) compilePath(path []element) (scanProg, bool) {
+	var p scanProg
+	var nodes []*syntax.Regexp // capture content, parallel to p.fields
+	// open is the field whose separator is still being collected; literals
+	// only count as a separator when they directly follow the capture.
+	open := -1
+	inPrefix := true
+	anchored := len(path) > 0 && path[0].kind == elemBegin
+	for _, e := range path {
+		switch e.kind {
+		case elemBegin:
+			// zero-width, does not break a literal run
+		case elemEnd:
+			p.anchorEnd = true
+		case elemAssert:
+			pl.warn(false, "assertion %#q is ignored", e.node)
+		case elemLiteral:
+			if open >= 0 || inPrefix {
+				pl.literal(e)
+			}
+			switch {
+			case open >= 0 && p.fields[open].trail == nil:
+				p.fields[open].delim = append(p.fields[open].delim, e.lit...)
+			case open >= 0:
+				pl.warn(true, "literal %q after the whitespace run following field %s is not supported",
+					e.lit, pl.label(&p.fields[open], nodes[open]))
+				open = -1
+			case inPrefix:
+				p.prefix = append(p.prefix, e.lit...)
+			}
+		case elemCapture, elemSkip:
+			col := e.col
+			if e.kind == elemSkip {
+				col = -1
+			}
+			inPrefix = false
+			p.fields = append(p.fields, pl.captureVM(e.node, col))
+			nodes = append(nodes, e.node)
+			open = len(p.fields) - 1
+		case elemSpace:
+			// A run may open the separator, where it ends the field, or
+			// follow its literal.
+			if open >= 0 {
+				f := &p.fields[open]
+				if len(f.delim) == 0 && f.lead == nil && e.run.min > 0 {
+					f.lead = e.run
+					continue
+				}
+				if len(f.delim) > 0 && f.trail == nil {
+					f.trail = e.run
+					continue
+				}
+			}
+			pl.unsupported(p.fields, nodes, e.node)
+			inPrefix = false
+			open = -1
+		default:
+			pl.unsupported(p.fields, nodes, e.node)
+			inPrefix = false
+			open = -1
+		}
+	}
+	if len(p.fields) > 0 && !anchored {
+		pl.warn(false, "pattern is not anchored with ^; the scanner matches from the start of the line")
+	}
+	pairBrackets(&p, nodes)
+
+	for i := range p.fields {
+		f := &p.fields[i]
+		name := pl.label(f, nodes[i])
+		// a field that a whitespace run ends is never the rest of the line
+		last := i == len(p.fields)-1 && f.lead == nil
+		reach := reachBytes(nodes[i])
+		if f.pair != nil {
+			// the closing bracket ends the field wherever it is
+			continue
+		}
+		// The closing quote ends an enclosed string, unless its escapes
+		// are doubled quotes and another quote may follow it.
+		if quotes, doubled, ok := quotedString(nodes[i]); ok &&
+			(last || !doubled || f.lead != nil || len(f.delim) > 0 && bytes.IndexByte(quotes, f.delim[0]) < 0) {
+			f.quotes, f.doubled, f.enclosed = quotes, doubled, true
+			if !last {
+				continue
+			}
+		}
+		if f.lead != nil {
+			if reach.intersects(f.lead.set) {
+				pl.warn(false, "field %s may contain the whitespace that ends it; the scanner splits at the first", name)
+			}
+			if len(f.delim) > 0 && f.lead.set.has(f.delim[0]) {
+				pl.warn(false, "whitespace run after field %s is followed by %q; the scanner does not backtrack", name, f.delim)
+			}
+			continue
+		}
+		if last && f.trail != nil {
+			pl.warn(true, "whitespace run after the last field %s is not supported", name)
+		}
+		if len(f.delim) == 0 && !last && f.hasClass {
+			f.byClass = true
+			if f.class.intersects(reachBytes(nodes[i+1])) {
+				pl.warn(false, "field %s runs into %s without a separator; the scanner does not backtrack",
+					name, pl.label(&p.fields[i+1], nodes[i+1]))
+			}
+			continue
+		}
+		if len(f.delim) == 0 && !last {
+			pl.warn(true, "field %s is not followed by a literal and is not a character class run", name)
+		}
+		if len(f.delim) == 0 || last {
+			p.suffix = f.delim
+			f.delim = nil
+			f.isLast = true
+			p.fields = p.fields[:i+1]
+			if len(p.suffix) > 0 && !f.enclosed {
+				// Only used to unescape; the anchored suffix and the
+				// content check already pin the field down.
+				if doubled, ok := quotedBody(nodes[i], p.suffix[0]); ok {
+					f.quotes, f.doubled = p.suffix[:1:1], doubled
+				}
+			}
+			f.suffixFirst = len(p.suffix) > 0 && !mayContain(nodes[i], p.suffix)
+			switch {
+			case len(p.suffix) > 0 && !p.anchorEnd && !f.suffixFirst:
+				pl.warn(false, "trailing literal %q after field %s is not end-anchored", p.suffix, name)
+			case !p.anchorEnd && !f.hasClass:
+				pl.warn(false, "last field %s is not end-anchored; it takes the rest of the line", name)
+			}
+			return p, last
+		}
+		if doubled, ok := quotedBody(nodes[i], f.delim[0]); ok && (!doubled || len(f.delim) > 1 && f.delim[1] != f.delim[0]) {
+			f.quotes, f.doubled = f.delim[:1:1], doubled
+			continue
+		}
+		if mayContain(nodes[i], f.delim) {
+			pl.warn(false, "field %s may contain its delimiter %q; the scanner splits at the first occurrence", name, f.delim)
+		}
+	}
+	return p, true
+}
+
+// factor merges the programs of all paths into one, sharing the leading
+// instructions they have in common and branching where they diverge.
+func factor(progs []scanProg) scanProg {
+	if len(progs) == 1 {
+		return progs[0]
+	}
+
+	common := 0
+	samePrefix := true
+	for _, p := range progs[1:] {
+		samePrefix = samePrefix && bytes.Equal(p.prefix, progs[0].prefix)
+	}
+	if samePrefix {
+	scan:
+		for ; common < len(progs[0].fields); common++ {
+			f := &progs[0].fields[common]
+			if f.isLast {
+				break
+			}
+			for _, p := range progs[1:] {
+				if common >= len(p.fields) || !f.same(&p.fields[common]) {
+					break scan
+				}
+			}
+		}
+	}
+
+	var top scanProg
+	alts := make([]scanProg, len(progs))
+	for i, p := range progs {
+		alts[i] = scanProg{suffix: p.suffix, anchorEnd: p.anchorEnd, fields: p.fields[common:]}
+		if !samePrefix {
+			alts[i].prefix = p.prefix
+		}
+	}
+	if samePrefix {
+		top.prefix = progs[0].prefix
+	}
+	top.fields = append(top.fields, progs[0].fields[:common]...)
+
+	branch := fieldVM{col: -1, alts: alts}
+	seen := map[int]bool{}
+	for i := range alts {
+		collectCols(&alts[i], func(c int) {
+			if !seen[c] {
+				seen[c] = true
+				branch.cols = append(branch.cols, c)
+			}
+		})
+	}
+	top.fields = append(top.fields, branch)
+	return top
+}
+
+func collectCols(p *scanProg, fn func(int)) {
+	for i := range p.fields {
+		f := &p.fields[i]
+		if f.col >= 0 {
+			fn(f.col)
+		}
+		for j := range f.alts {
+			collectCols(&f.alts[j], fn)
+		}
+	}
+}
+
+func countCols(p *scanProg) int {
+	n := 0
+	collectCols(p, func(int) { n++ })
+	return n
+}
+
+// captureVM builds the instruction for a named capture, or a skip field
+// when col is -1, from its content.
+func (pl *planner) captureVM(sub *syntax.Regexp, col int) fieldVM {
+	if vm, ok := pl.vms[sub]; ok {
+		return vm
+	}
+	vm := fieldVM{col: col, min: minLen(sub), max: -1}
+	if set, _, hi, ok := runClass(sub); ok {
+		vm.class = set
+		vm.hasClass = true
+		vm.max = hi
+	} else if re, err := regexp.Compile(`^(?:` + sub.String() + `)$`); err == nil {
+		vm.re = re
+	}
+	if pl.vms == nil {
+		pl.vms = map[*syntax.Regexp]fieldVM{}
+	}
+	pl.vms[sub] = vm
+	return vm
+}
+
+// minLen returns the minimum number of bytes n can match.
+func minLen(n *syntax.Regexp) int {
+	switch n.Op {
+	case syntax.OpLiteral:
+		return len(literalBytes(n))
+	case syntax.OpCharClass, syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return 1
+	case syntax.OpCapture, syntax.OpPlus:
+		return minLen(n.Sub[0])
+	case syntax.OpRepeat:
+		return n.Min * minLen(n.Sub[0])
+	case syntax.OpConcat:
+		total := 0
+		for _, sub := range n.Sub {
+			total += minLen(sub)
+		}
+		return total
+	case syntax.OpAlternate:
+		least := -1
+		for _, sub := range n.Sub {
+			if m := minLen(sub); least < 0 || m < least {
+				least = m
+			}
+		}
+		return max(least, 0)
+	default:
+		return 0
+	}
+}
+
+// foldVariants returns every spelling of a case-insensitive literal, or
+// nil if there are more than maxScanPaths.
+func foldVariants(runes []rune) [][]byte {
+	variants := [][]byte{nil}
+	for _, r := range runes {
+		folds := []rune{r}
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			folds = append(folds, f)
+		}
+		if len(variants)*len(folds) > maxScanPaths {
+			return nil
+		}
+		next := make([][]byte, 0, len(variants)*len(folds))
+		for _, v := range variants {
+			for _, f := range folds {
+				next = append(next, utf8.AppendRune(bytes.Clone(v), f))
+			}
+		}
+		variants = next
+	}
+	return variants
+}
+
+// literalBytes returns the UTF-8 encoding of a literal node.
+func literalBytes(n *syntax.Regexp) []byte {
+	return []byte(string(n.Rune))
+}
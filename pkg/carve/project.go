@@ -0,0 +1,106 @@
+package carve
+
+import (
+	"fmt"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// ============================================================
+// Column projection
+// ============================================================
+
+// unprojected is the plan a scanner was built with, kept by
+// WithProjection so later calls can select from every column again.
+type unprojected struct {
+	schema *arrow.Schema
+	prog   scanProg
+	capCol []int
+}
+
+// WithProjection limits the scanner's columns to the named fields, in the
+// order given. The plan still positions, and with Options.Verify checks,
+// every field, but the others are skipped rather than written: Schema,
+// and so a Writer built from it, holds the selected fields only, and Scan
+// writes names[i] to out[i]. Calling it without names restores all
+// columns.
+//
+// It fails on unknown or repeated names, leaving the scanner unchanged.
+// A projected scanner marshals with its projection applied.
+func (s *Scanner) WithProjection(names ...string) (*Scanner, error) {
+	base := s.base
+	if base == nil {
+		base = &unprojected{schema: s.schema, prog: s.scanProg, capCol: s.capCol}
+	}
+	if len(names) == 0 {
+		s.setColumns(base.schema, base.prog, base.capCol)
+		s.base = nil
+		return s, nil
+	}
+
+	all := base.schema.Fields()
+	remap := make([]int, len(all))
+	for i := range remap {
+		remap[i] = -1
+	}
+	fields := make([]arrow.Field, len(names))
+	for i, name := range names {
+		idx := base.schema.FieldIndices(name)
+		switch {
+		case len(idx) == 0:
+			return nil, fmt.Errorf("carve: projection: no field %q", name)
+		case remap[idx[0]] >= 0:
+			return nil, fmt.Errorf("carve: projection: field %q selected more than once", name)
+		}
+		remap[idx[0]] = i
+		fields[i] = all[idx[0]]
+	}
+
+	capCol := make([]int, len(base.capCol))
+	for i, c := range base.capCol {
+		capCol[i] = -1
+		if c >= 0 {
+			capCol[i] = remap[c]
+		}
+	}
+	s.setColumns(arrow.NewSchema(fields, nil), projectProg(&base.prog, remap), capCol)
+	s.base = base
+	return s, nil
+}
+
+func (s *Scanner) setColumns(schema *arrow.Schema, prog scanProg, capCol []int) {
+	s.schema = schema
+	s.scanProg = prog
+	s.capCol = capCol
+	s.numCols = len(schema.Fields())
+	s.scratch = make([][]byte, s.numCols)
+	s.compactPlan()
+}
+
+// projectProg copies p with its columns renumbered by remap. Fields whose
+// column maps to -1 become skip fields.
+func projectProg(p *scanProg, remap []int) scanProg {
+	q := *p
+	q.fields = make([]fieldVM, len(p.fields))
+	for i, f := range p.fields {
+		if f.col >= 0 {
+			f.col = remap[f.col]
+		}
+		if f.alts != nil {
+			alts := make([]scanProg, len(f.alts))
+			for j := range f.alts {
+				alts[j] = projectProg(&f.alts[j], remap)
+			}
+			f.alts = alts
+			var cols []int
+			for _, c := range f.cols {
+				if remap[c] >= 0 {
+					cols = append(cols, remap[c])
+				}
+			}
+			f.cols = cols
+		}
+		q.fields[i] = f
+	}
+	return q
+}
@@ -0,0 +1,174 @@
+package carve
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestScannerProjection(t *testing.T) {
+	const line = `10.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200 2326`
+	patterns := []string{
+		`^(?P<ip>\S+) (?P<ident>\S+) (?P<user>\S+) \[(?P<ts>[^\]]+)\] "(?P<req>[^"]*)" (?P<status>\d+) (?P<bytes>\d+|-)$`,
+		`^(?P<ip>\S+) (?P<ident>\S+) (?P<user>\S+) \[(?P<ts>[^\]]+)\] "(?P<req>[^"]*)" (?:(?P<status>\d+) )+(?P<bytes>\d+|-)$`,
+	}
+	for _, pattern := range patterns {
+		s, err := New(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.WithProjection("status", "ip"); err != nil {
+			t.Fatal(err)
+		}
+		if got := schemaNames(s); got != "status,ip" {
+			t.Fatalf("%v: schema = %s", s.Mode(), got)
+		}
+		for _, verify := range []bool{false, true} {
+			s.WithOptions(Options{ZeroCopy: true, Verify: verify})
+			out := make([][]byte, 2)
+			if !s.Scan([]byte(line), out) {
+				t.Fatalf("%v verify=%v: Scan rejected line: %v", s.Mode(), verify, s.Reason())
+			}
+			if string(out[0]) != "200" || string(out[1]) != "10.0.0.1" {
+				t.Errorf("%v verify=%v: Scan = %q", s.Mode(), verify, out)
+			}
+		}
+	}
+}
+
+func schemaNames(s *Scanner) string {
+	var names []string
+	for _, f := range s.Schema().Fields() {
+		names = append(names, f.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestScannerProjectionVerifiesSkippedFields(t *testing.T) {
+	s, err := New(`^(?P<a>\w+) (?P<n>\d+) (?P<b>\w+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WithProjection("b"); err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{ZeroCopy: true, Verify: true})
+	out := make([][]byte, 1)
+	if s.Scan([]byte("x y z"), out) || s.Reason() != RejectClass {
+		t.Errorf("reason %v, want %v", s.Reason(), RejectClass)
+	}
+}
+
+func TestScannerProjectionBranches(t *testing.T) {
+	s, err := New(`^(?:k=(?P<k>\d+)|v=(?P<v>[a-z]+)) (?P<rest>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WithProjection("rest", "k"); err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{ZeroCopy: true, Verify: true})
+	out := make([][]byte, 2)
+	for _, tt := range []struct {
+		line string
+		want []string
+	}{
+		{"k=12 rest of it", []string{"rest of it", "12"}},
+		{"v=ab tail", []string{"tail", ""}},
+	} {
+		if !s.Scan([]byte(tt.line), out) {
+			t.Fatalf("%q: Scan rejected line: %v", tt.line, s.Reason())
+		}
+		if string(out[0]) != tt.want[0] || string(out[1]) != tt.want[1] {
+			t.Errorf("%q: Scan = %q, want %q", tt.line, out, tt.want)
+		}
+	}
+}
+
+func TestScannerProjectionErrors(t *testing.T) {
+	s, err := New(`^(?P<a>\w+) (?P<b>\w+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, names := range [][]string{{"c"}, {"a", "a"}} {
+		if _, err := s.WithProjection(names...); err == nil {
+			t.Errorf("WithProjection(%q) succeeded", names)
+		}
+	}
+	if got := schemaNames(s); got != "a,b" {
+		t.Errorf("failed projection changed the schema to %s", got)
+	}
+
+	// Projections select from all fields, and none restores them.
+	if _, err := s.WithProjection("b"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WithProjection("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := schemaNames(s); got != "a" {
+		t.Errorf("schema = %s, want a", got)
+	}
+	if _, err := s.WithProjection(); err != nil {
+		t.Fatal(err)
+	}
+	if got := schemaNames(s); got != "a,b" {
+		t.Errorf("schema = %s, want a,b", got)
+	}
+}
+
+func TestWriterProjection(t *testing.T) {
+	s, err := New(`^(?P<ts>[^ ]+) (?P<level>[A-Z]+) (?P<msg>.+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WithProjection("level"); err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{ZeroCopy: true, Verify: true})
+
+	w := NewWriter(s.Schema(), memory.DefaultAllocator, 100)
+	lines := [][]byte{
+		[]byte("2023-01-01T10:00:00Z INFO started"),
+		[]byte("2023-01-01T10:00:01Z WARN slow"),
+	}
+	if _, err := w.WriteLinesSIMD(lines, s); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Release()
+	if rec.NumCols() != 1 || rec.NumRows() != 2 {
+		t.Fatalf("record is %d x %d, want 2 x 1", rec.NumRows(), rec.NumCols())
+	}
+	col := rec.Column(0).(*array.Binary)
+	if string(col.Value(0)) != "INFO" || string(col.Value(1)) != "WARN" {
+		t.Errorf("level = %q, %q", col.Value(0), col.Value(1))
+	}
+}
+
+func TestScannerProjectionAllocs(t *testing.T) {
+	s, err := New(`^(?P<user>\S+) +(?P<pid>\d+) +(?P<cmd>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WithProjection("cmd"); err != nil {
+		t.Fatal(err)
+	}
+	line := []byte("www-data   4242   nginx: worker process")
+	out := make([][]byte, 1)
+	// Skipped fields are not copied even without ZeroCopy.
+	for _, verify := range []bool{false, true} {
+		s.WithOptions(Options{Verify: verify})
+		allocs := testing.AllocsPerRun(100, func() {
+			s.Scan(line, out)
+		})
+		if allocs != 1 {
+			t.Fatalf("verify=%v: Scan allocates %v times per line, want 1", verify, allocs)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package carve
+
+import (
+	"bytes"
+	"regexp/syntax"
+)
+
+// ============================================================
+// Quoted fields
+// ============================================================
+
+// quoteEnd returns the length of the quoted field at the start of b, or
+// -1 if it is not closed. For an enclosed field b must start with one of
+// the field's quotes and the length includes both quotes; otherwise the
+// length stops before the closing quote.
+func (f *fieldVM) quoteEnd(b []byte) int {
+	if !f.enclosed {
+		return closeQuote(b, f.quotes[0], f.doubled)
+	}
+	if len(b) == 0 || bytes.IndexByte(f.quotes, b[0]) < 0 {
+		return -1
+	}
+	end := closeQuote(b[1:], b[0], f.doubled)
+	if end < 0 {
+		return -1
+	}
+	return end + 2
+}
+
+// closeQuote returns the index of the first q in b that is not escaped,
+// or -1. With backslash escapes every backslash escapes the byte after
+// it, so a quote is escaped when an odd run of backslashes precedes it.
+func closeQuote(b []byte, q byte, doubled bool) int {
+	for i := 0; i < len(b); {
+		j := bytes.IndexByte(b[i:], q)
+		if j < 0 {
+			return -1
+		}
+		i += j
+		if doubled {
+			if i+1 < len(b) && b[i+1] == q {
+				i += 2
+				continue
+			}
+			return i
+		}
+		k := i
+		for k > 0 && b[k-1] == '\\' {
+			k--
+		}
+		if (i-k)%2 == 0 {
+			return i
+		}
+		i++
+	}
+	return -1
+}
+
+// quoted returns the content of a quoted field from line[start:end]. With
+// Options.Unescape its escapes are resolved and an enclosed field loses
+// its quotes; unescaped content is built in s.unesc, which Scan sizes so
+// that it never has to grow mid-line unless a batch retains it.
+func (s *Scanner) quoted(f *fieldVM, line []byte, start, end int) []byte {
+	if !s.opts.Unescape {
+		return slice(line, start, end, s.opts.ZeroCopy)
+	}
+	b := line[start:end]
+	q := f.quotes[0]
+	if f.enclosed {
+		if len(b) < 2 || bytes.IndexByte(f.quotes, b[0]) < 0 || b[len(b)-1] != b[0] {
+			return slice(line, start, end, s.opts.ZeroCopy)
+		}
+		q = b[0]
+		start, end = start+1, end-1
+		b = line[start:end]
+	}
+	esc := byte('\\')
+	if f.doubled {
+		esc = q
+	}
+	if bytes.IndexByte(b, esc) < 0 {
+		return slice(line, start, end, s.opts.ZeroCopy)
+	}
+
+	from := len(s.unesc)
+	for i := 0; i < len(b); i++ {
+		if b[i] == esc && i+1 < len(b) && (!f.doubled || b[i+1] == q) {
+			i++
+		}
+		s.unesc = append(s.unesc, b[i])
+	}
+	v := s.unesc[from:len(s.unesc):len(s.unesc)]
+	if !s.opts.ZeroCopy {
+		return bytes.Clone(v)
+	}
+	return v
+}
+
+// quotedBody reports whether n is the body of a string quoted with q: a
+// repetition of either a run of bytes other than the quote (and the
+// backslash), or an escape, which is a backslash and any character or a
+// doubled quote. Such a body ends exactly at the first unescaped q.
+func quotedBody(n *syntax.Regexp, q byte) (doubled, ok bool) {
+	if (n.Op != syntax.OpStar && n.Op != syntax.OpPlus) || n.Sub[0].Op != syntax.OpAlternate || len(n.Sub[0].Sub) != 2 {
+		return false, false
+	}
+	plain, esc := n.Sub[0].Sub[0], n.Sub[0].Sub[1]
+	if plain.Op == syntax.OpConcat || plain.Op == syntax.OpLiteral {
+		plain, esc = esc, plain
+	}
+	if plain.Op == syntax.OpPlus || plain.Op == syntax.OpStar {
+		plain = plain.Sub[0]
+	}
+	set, ok := classSet(plain)
+	if !ok || set.has(q) {
+		return false, false
+	}
+
+	switch {
+	case esc.Op == syntax.OpLiteral && string(esc.Rune) == string([]rune{rune(q), rune(q)}) && esc.Flags&syntax.FoldCase == 0:
+		return true, true
+	case esc.Op == syntax.OpConcat && len(esc.Sub) == 2 && !set.has('\\') &&
+		esc.Sub[0].Op == syntax.OpLiteral && string(esc.Sub[0].Rune) == `\` && minLen(esc.Sub[1]) == 1:
+		switch esc.Sub[1].Op {
+		case syntax.OpAnyChar, syntax.OpAnyCharNotNL, syntax.OpCharClass, syntax.OpLiteral:
+			return false, true
+		}
+	}
+	return false, false
+}
+
+// quotedString reports whether n is a whole quoted string: an opening
+// quote, a quotedBody and the same quote, or an alternation of such
+// strings with different quotes and the same escape style.
+func quotedString(n *syntax.Regexp) (quotes []byte, doubled, ok bool) {
+	alts := []*syntax.Regexp{n}
+	if n.Op == syntax.OpAlternate {
+		alts = n.Sub
+	}
+	for i, alt := range alts {
+		if alt.Op != syntax.OpConcat || len(alt.Sub) != 3 {
+			return nil, false, false
+		}
+		open, close := alt.Sub[0], alt.Sub[2]
+		if open.Op != syntax.OpLiteral || len(open.Rune) != 1 || open.Rune[0] >= 0x80 ||
+			close.Op != syntax.OpLiteral || len(close.Rune) != 1 || close.Rune[0] != open.Rune[0] ||
+			(open.Flags|close.Flags)&syntax.FoldCase != 0 {
+			return nil, false, false
+		}
+		q := byte(open.Rune[0])
+		d, ok := quotedBody(alt.Sub[1], q)
+		if !ok || (i > 0 && d != doubled) || bytes.IndexByte(quotes, q) >= 0 {
+			return nil, false, false
+		}
+		quotes, doubled = append(quotes, q), d
+	}
+	return quotes, doubled, true
+}
@@ -0,0 +1,233 @@
+package carve
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"regexp"
+)
+
+// ============================================================
+// Multiline records
+// ============================================================
+
+// RecordOptions say which lines start a record, such as a log entry with
+// a stack trace, and which continue the one before them. Exactly one of
+// StartPrefix, StartPattern and Indented must be set.
+type RecordOptions struct {
+	// StartPrefix starts a record at each line that begins with it.
+	StartPrefix string
+	// StartPattern starts a record at each line it matches.
+	StartPattern *regexp.Regexp
+	// Indented makes the lines that begin with a space or a tab continue
+	// the record before them, and starts one at every other line.
+	Indented bool
+	// MaxLines and MaxBytes limit a record's lines, the first included,
+	// and its length; 0 means no limit. Continuation lines past either
+	// are dropped, and Truncated reports the record.
+	MaxLines int
+	MaxBytes int
+}
+
+// RecordReader joins the lines of a LineReader into records, the
+// continuation lines of each appended to its first line with a \n. A
+// record of one line is the LineReader's line; longer ones are copied into
+// the RecordReader's own buffer. Lines that come before the first record
+// start form records of their own.
+//
+// Records are scanned with Options.Multiline, which joins the
+// continuation lines to the last field of the first line.
+type RecordReader struct {
+	lr     *LineReader
+	opts   RecordOptions
+	prefix []byte
+	err    error
+	buf    []byte // multi-line records; the pending one is always last
+	cur    []byte // the pending record, of lines lines
+	lines  int
+	inBuf  bool // cur has been copied to buf[start:]
+	start  int
+	trunc  bool
+	recs   [][]byte
+	cut    []bool
+}
+
+// NewRecordReader returns a RecordReader assembling the lines of lr.
+func NewRecordReader(lr *LineReader, opts RecordOptions) (*RecordReader, error) {
+	rules := 0
+	for _, set := range []bool{opts.StartPrefix != "", opts.StartPattern != nil, opts.Indented} {
+		if set {
+			rules++
+		}
+	}
+	if rules != 1 {
+		return nil, errors.New("carve: records need exactly one of StartPrefix, StartPattern and Indented")
+	}
+	if opts.MaxLines < 0 || opts.MaxBytes < 0 {
+		return nil, errors.New("carve: record limits must not be negative")
+	}
+	return &RecordReader{lr: lr, opts: opts, prefix: []byte(opts.StartPrefix)}, nil
+}
+
+// Reset makes rr and its LineReader read from r, discarding the record
+// being assembled.
+func (rr *RecordReader) Reset(r io.Reader) {
+	rr.lr.Reset(r)
+	rr.err = nil
+	rr.cur, rr.lines, rr.inBuf, rr.trunc = nil, 0, false, false
+	rr.buf, rr.recs, rr.cut = rr.buf[:0], rr.recs[:0], rr.cut[:0]
+}
+
+// Next returns the records completed by the next batch of lines. They
+// are valid until the following call. A record is only complete once the
+// line starting the next one is read, so the last one is held back until
+// then or the end of the input. Next returns nil and the LineReader's
+// error once the records are exhausted.
+func (rr *RecordReader) Next() ([][]byte, error) {
+	rr.recs, rr.cut = rr.recs[:0], rr.cut[:0]
+	if rr.inBuf {
+		rr.buf = rr.buf[:copy(rr.buf, rr.buf[rr.start:])]
+		rr.start = 0
+		rr.cur = rr.buf
+	} else {
+		rr.buf = rr.buf[:0]
+	}
+	for len(rr.recs) == 0 {
+		if rr.err != nil {
+			rr.emit()
+			if len(rr.recs) == 0 {
+				return nil, rr.err
+			}
+			break
+		}
+		lines, err := rr.lr.Next()
+		rr.err = err
+		for i, line := range lines {
+			if rr.lines == 0 || rr.starts(line) {
+				rr.emit()
+				rr.cur, rr.lines = line, 1
+			} else {
+				rr.join(line)
+			}
+			if rr.lr.Truncated(i) {
+				rr.trunc = true
+			}
+		}
+		// the LineReader reuses its buffer, so a record still open
+		// has to be kept in ours
+		if rr.lines > 0 && !rr.inBuf {
+			rr.own()
+		}
+	}
+	return rr.recs, nil
+}
+
+// Truncated reports whether record i of the last batch lost continuation
+// lines to RecordOptions.MaxLines or MaxBytes, or has a line the
+// LineReader truncated.
+func (rr *RecordReader) Truncated(i int) bool {
+	return i < len(rr.cut) && rr.cut[i]
+}
+
+// starts reports whether line begins a record.
+func (rr *RecordReader) starts(line []byte) bool {
+	switch {
+	case rr.opts.StartPattern != nil:
+		return rr.opts.StartPattern.Match(line)
+	case len(rr.prefix) > 0:
+		return bytes.HasPrefix(line, rr.prefix)
+	default:
+		return len(line) == 0 || line[0] != ' ' && line[0] != '\t'
+	}
+}
+
+// join appends a continuation line to the pending record.
+func (rr *RecordReader) join(line []byte) {
+	if rr.trunc ||
+		rr.opts.MaxLines > 0 && rr.lines == rr.opts.MaxLines ||
+		rr.opts.MaxBytes > 0 && len(rr.cur)+1+len(line) > rr.opts.MaxBytes {
+		rr.trunc = true
+		return
+	}
+	if !rr.inBuf {
+		rr.own()
+	}
+	rr.buf = append(rr.buf, '\n')
+	rr.buf = append(rr.buf, line...)
+	rr.cur = rr.buf[rr.start:]
+	rr.lines++
+}
+
+// own copies the pending record to the end of buf.
+func (rr *RecordReader) own() {
+	rr.start = len(rr.buf)
+	rr.buf = append(rr.buf, rr.cur...)
+	rr.cur = rr.buf[rr.start:]
+	rr.inBuf = true
+}
+
+// emit adds the pending record, if any, to the batch.
+func (rr *RecordReader) emit() {
+	if rr.lines == 0 {
+		return
+	}
+	rr.recs = append(rr.recs, rr.cur[:len(rr.cur):len(rr.cur)])
+	rr.cut = append(rr.cut, rr.trunc)
+	rr.cur, rr.lines, rr.inBuf, rr.trunc = nil, 0, false, false
+}
+
+// scanRecord scans the first line of a multi-line record, which ends at
+// n, and appends the rest of the record to the column of the field that
+// ends the line.
+func (s *Scanner) scanRecord(record []byte, n int, out [][]byte) bool {
+	first := record[:n]
+	if s.opts.TrimCR && n > 0 && first[n-1] == '\r' {
+		first = first[:n-1]
+	}
+	s.opts.Multiline = false
+	ok := s.Scan(first, out)
+	s.opts.Multiline = true
+	c := s.recordCol()
+	if !ok || c < 0 {
+		return ok
+	}
+	v, rest := out[c], record[len(first):]
+	switch {
+	case !s.opts.ZeroCopy:
+		out[c] = append(v[:len(v):len(v)], rest...)
+	case len(v) > 0 && len(first) > 0 && &v[len(v)-1] == &first[len(first)-1]:
+		// a slice of the line up to its end only has to be extended
+		out[c] = record[len(first)-len(v):]
+	default:
+		from := 0
+		if s.retain {
+			from = len(s.joined)
+		}
+		s.joined = append(append(s.joined[:from], v...), rest...)
+		out[c] = s.joined[from:len(s.joined):len(s.joined)]
+	}
+	return true
+}
+
+// recordCol returns the column of the field that ended the line just
+// scanned, or -1 if it has none: the named group that ends last for the
+// regexp fallback, the last field of the plan, or of the alternative
+// that matched, otherwise.
+func (s *Scanner) recordCol() int {
+	if s.mode == ModeRegexp || len(s.fields) > 0 && s.fields[len(s.fields)-1].alts != nil {
+		return s.endCol
+	}
+	return s.lastCol()
+}
+
+// lastCol returns the column of p's last field, or -1 if p does not end
+// in one.
+func (p *scanProg) lastCol() int {
+	if len(p.fields) == 0 {
+		return -1
+	}
+	if f := &p.fields[len(p.fields)-1]; f.isLast && f.alts == nil {
+		return f.col
+	}
+	return -1
+}
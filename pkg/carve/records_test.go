@@ -0,0 +1,305 @@
+package carve
+
+import (
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+const javaTrace = "2023-01-01T10:00:00Z INFO started\n" +
+	"2023-01-01T10:00:01Z ERROR request failed\n" +
+	"java.lang.IllegalStateException: boom\n" +
+	"\tat com.example.Handler.handle(Handler.java:42)\n" +
+	"\tat com.example.Server.run(Server.java:7)\n" +
+	"Caused by: java.io.IOException: closed\n" +
+	"\t... 2 more\n" +
+	"2023-01-01T10:00:02Z INFO done"
+
+// readAllRecords collects every record of rr, marking truncated ones
+// with a trailing *.
+func readAllRecords(t *testing.T, rr *RecordReader) []string {
+	t.Helper()
+	var got []string
+	for {
+		recs, err := rr.Next()
+		for i, rec := range recs {
+			if rr.Truncated(i) {
+				rec = append(rec, '*')
+			}
+			got = append(got, string(rec))
+		}
+		if err == io.EOF {
+			return got
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRecordReader(t *testing.T) {
+	lines := strings.Split(javaTrace, "\n")
+	trace := strings.Join(lines[1:7], "\n")
+	rules := []struct {
+		name string
+		opts RecordOptions
+		want []string
+	}{
+		{"prefix", RecordOptions{StartPrefix: "2023-"}, []string{lines[0], trace, lines[7]}},
+		{"pattern", RecordOptions{StartPattern: regexp.MustCompile(`^\d{4}-\d\d-\d\d`)}, []string{lines[0], trace, lines[7]}},
+		// Unindented lines of a trace start records of their own.
+		{"indented", RecordOptions{Indented: true}, []string{
+			lines[0], lines[1], strings.Join(lines[2:5], "\n"), strings.Join(lines[5:7], "\n"), lines[7],
+		}},
+	}
+	for _, rule := range rules {
+		for _, size := range []int{1, 16, 0} {
+			for _, input := range []string{javaTrace, javaTrace + "\n"} {
+				lr := NewLineReader(iotest.OneByteReader(strings.NewReader(input)), size)
+				rr, err := NewRecordReader(lr, rule.opts)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := readAllRecords(t, rr); strings.Join(got, "|") != strings.Join(rule.want, "|") {
+					t.Fatalf("%s/%d: records %q, want %q", rule.name, size, got, rule.want)
+				}
+			}
+		}
+	}
+}
+
+func TestRecordReaderLeadingContinuation(t *testing.T) {
+	lr := NewLineReader(strings.NewReader("\tat orphan\n\tat orphan2\nstart\n\tat x\n"), 0)
+	rr, err := NewRecordReader(lr, RecordOptions{Indented: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"\tat orphan\n\tat orphan2", "start\n\tat x"}
+	if got := readAllRecords(t, rr); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Fatalf("records %q, want %q", got, want)
+	}
+}
+
+func TestRecordReaderLimits(t *testing.T) {
+	tests := []struct {
+		opts RecordOptions
+		want string
+	}{
+		{RecordOptions{StartPrefix: "2023-", MaxLines: 3},
+			"2023-01-01T10:00:01Z ERROR request failed\njava.lang.IllegalStateException: boom\n\tat com.example.Handler.handle(Handler.java:42)*"},
+		{RecordOptions{StartPrefix: "2023-", MaxBytes: 80},
+			"2023-01-01T10:00:01Z ERROR request failed\njava.lang.IllegalStateException: boom*"},
+		{RecordOptions{StartPrefix: "2023-", MaxBytes: 10},
+			"2023-01-01T10:00:01Z ERROR request failed*"},
+	}
+	for _, tt := range tests {
+		rr, err := NewRecordReader(NewLineReader(strings.NewReader(javaTrace), 0), tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := readAllRecords(t, rr)
+		if len(got) != 3 || got[1] != tt.want || strings.HasSuffix(got[0], "*") || strings.HasSuffix(got[2], "*") {
+			t.Errorf("%+v: records %q, want %q second", tt.opts, got, tt.want)
+		}
+	}
+}
+
+func TestRecordReaderTruncatedLines(t *testing.T) {
+	lr := NewLineReader(strings.NewReader(javaTrace), 0).WithOptions(LineOptions{MaxLength: 40, Policy: LongLineTruncate})
+	rr, err := NewRecordReader(lr, RecordOptions{StartPrefix: "2023-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The first record fits; the trace has long lines.
+	got := readAllRecords(t, rr)
+	if len(got) != 3 || strings.HasSuffix(got[0], "*") || !strings.HasSuffix(got[1], "*") {
+		t.Errorf("records %q, want the second truncated", got)
+	}
+}
+
+func TestRecordReaderOptions(t *testing.T) {
+	for _, opts := range []RecordOptions{
+		{},
+		{StartPrefix: "x", Indented: true},
+		{Indented: true, MaxLines: -1},
+	} {
+		if _, err := NewRecordReader(NewLineReader(strings.NewReader(""), 0), opts); err == nil {
+			t.Errorf("%+v: expected an error", opts)
+		}
+	}
+}
+
+func TestScannerMultiline(t *testing.T) {
+	const record = "2023-01-01T10:00:01Z ERROR request failed\r\n\tat a.B(B.java:1)\r\n\tat c.D(D.java:2)\r"
+	const msg = "request failed\r\n\tat a.B(B.java:1)\r\n\tat c.D(D.java:2)"
+	scanners := map[string]func() (*Scanner, error){
+		"template": func() (*Scanner, error) { return NewTemplate("{ts} {level} {msg...}") },
+		"compiled": func() (*Scanner, error) { return New(`^(?P<ts>\S+) (?P<level>[A-Z]+) (?P<msg>.*)$`) },
+		"regexp":   func() (*Scanner, error) { return New(`^(?P<ts>\S+) (?:(?P<level>[A-Z]+) )+(?P<msg>.*)$`) },
+	}
+	for name, build := range scanners {
+		s, err := build()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, opts := range []Options{
+			{Multiline: true, TrimCR: true, ZeroCopy: true, Verify: true},
+			{Multiline: true, TrimCR: true, Verify: true},
+			{Multiline: true, TrimCR: true, ZeroCopy: true, Unescape: true},
+		} {
+			s.WithOptions(opts)
+			out := make([][]byte, 3)
+			if !s.Scan([]byte(record), out) {
+				t.Fatalf("%s %+v: Scan rejected record: %v", name, opts, s.Reason())
+			}
+			if string(out[0]) != "2023-01-01T10:00:01Z" || string(out[1]) != "ERROR" || string(out[2]) != msg {
+				t.Errorf("%s %+v: Scan = %q", name, opts, out)
+			}
+		}
+
+		// Only the first line is verified.
+		s.WithOptions(Options{Multiline: true, Verify: true})
+		if s.Scan([]byte("garbage\n2023-01-01T10:00:01Z ERROR x"), make([][]byte, 3)) {
+			t.Errorf("%s: Scan accepted a record with a bad first line", name)
+		}
+		// Without the option the record is one line.
+		s.WithOptions(Options{ZeroCopy: true})
+		out := make([][]byte, 3)
+		s.Scan([]byte("2023-01-01T10:00:01Z ERROR x\ny z"), out)
+		if name != "regexp" && string(out[2]) != "x\ny z" {
+			t.Errorf("%s: Scan without Multiline = %q", name, out)
+		}
+	}
+}
+
+func TestScannerMultilineLastField(t *testing.T) {
+	// A trailing literal after the last field is dropped from the joined
+	// value, like it is from a line.
+	s, err := New(`^(?P<level>[A-Z]+) \[(?P<msg>[^\]]*)\]$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([][]byte, 2)
+	for _, zeroCopy := range []bool{true, false} {
+		s.WithOptions(Options{Multiline: true, ZeroCopy: zeroCopy, Verify: true})
+		if !s.Scan([]byte("ERROR [boom]\n\tat x"), out) || string(out[1]) != "boom\n\tat x" {
+			t.Errorf("zeroCopy=%v: Scan = %q", zeroCopy, out)
+		}
+	}
+
+	// A projection without the last field drops the continuation lines.
+	s, err = New(`^(?P<level>[A-Z]+) (?P<msg>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WithProjection("level"); err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{Multiline: true, ZeroCopy: true})
+	if !s.Scan([]byte("ERROR boom\n\tat x"), out[:1]) || string(out[0]) != "ERROR" {
+		t.Errorf("projected Scan = %q", out[:1])
+	}
+}
+
+func TestScannerMultilineBranch(t *testing.T) {
+	// The continuation lines go to the field that ends the line, here in
+	// the alternative that matched or the group that ends last.
+	const record = "ERROR boom\n  at foo\n  at bar"
+	for _, pattern := range []string{
+		`^(?P<lvl>\w+) (?:(?P<a>x)|(?P<msg>.+))$`,
+		`^(?P<lvl>\w+) (?:(?P<a>x)|(?:(?P<b>y)|(?P<msg>.+)))$`,
+		`^(?P<lvl>\w+) (?:(?P<msg>.+)|(?P<a>x))+$`,
+	} {
+		s, err := New(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		schema := s.Schema()
+		a, msg := schema.FieldIndices("a")[0], schema.FieldIndices("msg")[0]
+		for _, opts := range []Options{
+			{Multiline: true, ZeroCopy: true},
+			{Multiline: true, ZeroCopy: true, Verify: true},
+			{Multiline: true, Verify: true},
+		} {
+			s.WithOptions(opts)
+			out := make([][]byte, len(schema.Fields()))
+			if !s.Scan([]byte(record), out) {
+				t.Fatalf("%s %+v: Scan rejected record: %v", pattern, opts, s.Reason())
+			}
+			if string(out[0]) != "ERROR" || out[a] != nil || string(out[msg]) != "boom\n  at foo\n  at bar" {
+				t.Errorf("%s %+v: Scan = %q", pattern, opts, out)
+			}
+		}
+	}
+}
+
+func TestWriterMultiline(t *testing.T) {
+	s, err := New(`^(?P<a>[^ ]+) \[(?P<msg>[^\]]*)\]$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{Multiline: true, ZeroCopy: true, Verify: true})
+	// The trailing ] keeps msg from ending the first line, so both
+	// records are joined into copies, which the batch must keep apart.
+	records := [][]byte{
+		[]byte("x [one]\n  at A"),
+		[]byte("y [two]\n  at B"),
+		[]byte("z [three]"),
+	}
+	w := NewWriter(s.Schema(), memory.DefaultAllocator, 100)
+	if _, err := w.WriteLinesSIMD(records, s); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Release()
+
+	col := rec.Column(1).(*array.Binary)
+	for i, want := range []string{"one\n  at A", "two\n  at B", "three"} {
+		if got := col.ValueString(i); got != want {
+			t.Errorf("row %d msg = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestRecordReaderAllocs(t *testing.T) {
+	input := strings.Repeat(javaTrace+"\n", 100)
+	r := strings.NewReader(input)
+	lr := NewLineReader(r, 1024)
+	rr, err := NewRecordReader(lr, RecordOptions{StartPrefix: "2023-"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewTemplate("{ts} {level} {msg...}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{Multiline: true, ZeroCopy: true})
+	out := make([][]byte, 3)
+	pass := func() {
+		r.Reset(input)
+		rr.Reset(r)
+		for {
+			recs, err := rr.Next()
+			if err != nil {
+				break
+			}
+			for _, rec := range recs {
+				s.Scan(rec, out)
+			}
+		}
+	}
+	pass()
+	// Once the buffers have grown, assembling and scanning allocate
+	// nothing.
+	allocs := testing.AllocsPerRun(20, pass)
+	if allocs != 0 {
+		t.Fatalf("RecordReader allocates %v times per pass", allocs)
+	}
+}
@@ -0,0 +1,1043 @@
+package carve
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestScannerScan(t *testing.T) {
+	tests := []struct {
+		name      string
+		pattern   string
+		line      string
+		want      []string
+		unescaped []string // want with Options.Unescape, if it differs
+	}{
+		{
+			name:    "single byte separators",
+			pattern: `^(?P<ts>[^ ]+) (?P<level>\w+) (?P<msg>.+)`,
+			line:    "2023-01-01T10:00:00.123Z INFO Application started",
+			want:    []string{"2023-01-01T10:00:00.123Z", "INFO", "Application started"},
+		},
+		{
+			name:    "multi-byte separator",
+			pattern: `(?P<a>[^ ]+) - (?P<b>[^ ]+) - (?P<c>.*)`,
+			line:    "alpha - beta - gamma delta",
+			want:    []string{"alpha", "beta", "gamma delta"},
+		},
+		{
+			name:    "quote and space separator",
+			pattern: `"(?P<req>[^"]*)" (?P<status>\d+)`,
+			line:    `"GET / HTTP/1.1" 200`,
+			want:    []string{"GET / HTTP/1.1", "200"},
+		},
+		{
+			name:    "bracket separator",
+			pattern: `\[(?P<ts>[^\]]+)\] (?P<msg>.*)`,
+			line:    "[10/Oct/2000:13:55:36 -0700] hello world",
+			want:    []string{"10/Oct/2000:13:55:36 -0700", "hello world"},
+		},
+		{
+			name:    "adjacent class fields",
+			pattern: `^(?P<status>\d+)(?P<unit>[a-z]+) (?P<rest>.*)`,
+			line:    "200ms took a while",
+			want:    []string{"200", "ms", "took a while"},
+		},
+		{
+			name:    "bounded adjacent class fields",
+			pattern: `^(?P<year>\d{4})(?P<month>\d{2})(?P<day>\d{2})`,
+			line:    "20231015",
+			want:    []string{"2023", "10", "15"},
+		},
+		{
+			name:    "adjacent class fields with non-ASCII content",
+			pattern: `^(?P<word>[^0-9 ]+)(?P<num>[0-9]+) (?P<rest>.*)`,
+			line:    "größe42 ok",
+			want:    []string{"größe", "42", "ok"},
+		},
+		{
+			name:    "bracketed timestamp with a space",
+			pattern: `^(?P<ip>\S+) - - \[(?P<ts>[^\]]+)\] "(?P<req>[^"]*)" (?P<status>\d+)$`,
+			line:    `10.0.0.1 - - [10/Oct/2000:13:55:36 -0700] "GET / HTTP/1.0" 200`,
+			want:    []string{"10.0.0.1", "10/Oct/2000:13:55:36 -0700", "GET / HTTP/1.0", "200"},
+		},
+		{
+			name:    "bracketed tag followed by a space",
+			pattern: `^(?P<level>[A-Z]+) \[(?P<component>[^\]]+)\] (?P<msg>.*)$`,
+			line:    "INFO [controller] sync done] ok",
+			want:    []string{"INFO", "controller", "sync done] ok"},
+		},
+		{
+			name:    "adjacent brackets",
+			pattern: `^\[(?P<a>[^\]]*)\]\[(?P<b>\d+)\](?P<rest>.*)$`,
+			line:    "[x y][42]tail",
+			want:    []string{"x y", "42", "tail"},
+		},
+		{
+			name:    "angle brackets",
+			pattern: `^<(?P<pri>\d{1,3})>(?P<msg>.*)$`,
+			line:    "<13>Oct 11 22:14:15 host app: hi",
+			want:    []string{"13", "Oct 11 22:14:15 host app: hi"},
+		},
+		{
+			name:    "space runs between columns",
+			pattern: `^(?P<user>\S+) +(?P<pid>\d+) +(?P<cpu>[\d.]+) +(?P<cmd>.*)$`,
+			line:    "root         1  0.0 /sbin/init splash",
+			want:    []string{"root", "1", "0.0", "/sbin/init splash"},
+		},
+		{
+			name:    "mixed tab and space runs",
+			pattern: `^(?P<name>\S+)\s+(?P<ready>\d+/\d+)\s+(?P<status>\w+)\s+(?P<age>\S+)$`,
+			line:    "web-7d4b9c-x2x   1/1 \t Running   3d",
+			want:    []string{"web-7d4b9c-x2x", "1/1", "Running", "3d"},
+		},
+		{
+			name:    "space runs around a literal",
+			pattern: `^(?P<host>\S+)\s+-\s+(?P<msg>.*)$`,
+			line:    "db1   -    connection reset",
+			want:    []string{"db1", "connection reset"},
+		},
+		{
+			name:    "space run after a literal",
+			pattern: `^(?P<key>[\w-]+): *(?P<value>.*)$`,
+			line:    "Content-Type:    text/plain",
+			want:    []string{"Content-Type", "text/plain"},
+		},
+		{
+			name:    "trailing padding",
+			pattern: `^(?P<a>\w+) +(?P<b>\w+) +$`,
+			line:    "left  right   ",
+			want:    []string{"left", "right"},
+		},
+		{
+			name:      "backslash escapes between quotes",
+			pattern:   `^(?P<ip>\S+) "(?P<req>(?:[^"\\]|\\.)*)" (?P<status>\d+)$`,
+			line:      `10.0.0.1 "GET /a b \"x\" HTTP/1.1" 200`,
+			want:      []string{"10.0.0.1", `GET /a b \"x\" HTTP/1.1`, "200"},
+			unescaped: []string{"10.0.0.1", `GET /a b "x" HTTP/1.1`, "200"},
+		},
+		{
+			name:      "escaped backslash before the closing quote",
+			pattern:   `^"(?P<a>(?:\\.|[^"\\])*)" (?P<b>\w+)$`,
+			line:      `"c:\\dir\\" next`,
+			want:      []string{`c:\\dir\\`, "next"},
+			unescaped: []string{`c:\dir\`, "next"},
+		},
+		{
+			name:      "doubled quotes",
+			pattern:   `^"(?P<a>(?:[^"]|"")*)",(?P<b>\d+)$`,
+			line:      `"say ""hi"", ok",5`,
+			want:      []string{`say ""hi"", ok`, "5"},
+			unescaped: []string{`say "hi", ok`, "5"},
+		},
+		{
+			name:      "enclosed string",
+			pattern:   `^(?P<agent>"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*') (?P<n>\d+)$`,
+			line:      `"Mozilla/5.0 (X11; Linux) \"beta\"" 5`,
+			want:      []string{`"Mozilla/5.0 (X11; Linux) \"beta\""`, "5"},
+			unescaped: []string{`Mozilla/5.0 (X11; Linux) "beta"`, "5"},
+		},
+		{
+			name:      "enclosed string with the other quote",
+			pattern:   `^(?P<agent>"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*') (?P<n>\d+)$`,
+			line:      `'it\'s "fine"' 7`,
+			want:      []string{`'it\'s "fine"'`, "7"},
+			unescaped: []string{`it's "fine"`, "7"},
+		},
+		{
+			name:      "enclosed strings without a separator",
+			pattern:   `^(?P<a>"(?:[^"\\]|\\.)*")(?P<b>"(?:[^"\\]|\\.)*")$`,
+			line:      `"a b""c\"d"`,
+			want:      []string{`"a b"`, `"c\"d"`},
+			unescaped: []string{"a b", `c"d`},
+		},
+		{
+			name:      "quoted last field",
+			pattern:   `^(?P<level>\w+) "(?P<msg>(?:[^"\\]|\\.)*)"$`,
+			line:      `WARN "disk \"sda\" full"`,
+			want:      []string{"WARN", `disk \"sda\" full`},
+			unescaped: []string{"WARN", `disk "sda" full`},
+		},
+		{
+			name:    "unnamed group between named ones",
+			pattern: `^(?P<a>\w+) ([^ ]+) (?P<b>\d+)$`,
+			line:    "alpha x-y 42",
+			want:    []string{"alpha", "42"},
+		},
+		{
+			name:    "uncaptured segments",
+			pattern: `^(?P<ip>\S+) \S+ (?P<user>\S+) \[(?P<ts>[^\]]+)\] "(?P<req>[^"]*)" (?P<status>\d+) \d+$`,
+			line:    `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+			want:    []string{"127.0.0.1", "frank", "10/Oct/2000:13:55:36 -0700", "GET /apache_pb.gif HTTP/1.0", "200"},
+		},
+		{
+			name:    "uncaptured leading segment",
+			pattern: `^\d+ (?P<msg>.*)$`,
+			line:    "1697000000 service started",
+			want:    []string{"service started"},
+		},
+		{
+			name:    "uncaptured trailing segment",
+			pattern: `^(?P<level>[A-Z]+) .*$`,
+			line:    "WARN disk almost full",
+			want:    []string{"WARN"},
+		},
+		{
+			name:    "unnamed group in an optional group",
+			pattern: `^(?P<host>[^: ]+)(?::(\d+))? (?P<msg>.*)$`,
+			line:    "db1:5432 connection reset",
+			want:    []string{"db1", "connection reset"},
+		},
+		{
+			name:    "unnamed quoted group",
+			pattern: `^(?P<a>\w+) ("[^"]*") (?P<b>\w+)$`,
+			line:    `x "y z" w`,
+			want:    []string{"x", "w"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.pattern)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			if n := len(s.Schema().Fields()); n != len(tt.want) {
+				t.Fatalf("schema has %d columns, want %d", n, len(tt.want))
+			}
+			for _, opts := range []Options{
+				{ZeroCopy: true},
+				{ZeroCopy: true, Verify: true},
+				{ZeroCopy: true, Unescape: true},
+				{Verify: true, Unescape: true},
+			} {
+				// a partial plan only splits lines the same way
+				if opts.Verify && s.Mode() == ModePartial {
+					continue
+				}
+				s.WithOptions(opts)
+				want := tt.want
+				if opts.Unescape && tt.unescaped != nil {
+					want = tt.unescaped
+				}
+				out := make([][]byte, len(want))
+				if !s.Scan([]byte(tt.line), out) {
+					t.Fatalf("%+v: Scan rejected line: %v", opts, s.Reason())
+				}
+				for i, w := range want {
+					if string(out[i]) != w {
+						t.Errorf("%+v: field %d = %q, want %q", opts, i, out[i], w)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestScannerSplitPlan(t *testing.T) {
+	tests := []struct {
+		pattern string
+		split   bool
+	}{
+		{`^(?P<ts>[^ ]+) (?P<level>\w+) (?P<msg>.+)`, true},
+		{`^\[(?P<a>[^ ]+) \S+ (?P<b>[^|]*)\|(?P<c>.*)`, true},
+		{`(?P<a>[^ ]+) - (?P<b>[^ ]+) - (?P<c>.*)`, false},
+		{`^(?P<status>\d+)(?P<unit>[a-z]+) (?P<rest>.*)`, false},
+		{`^(?P<a>[^ ]+) (?P<b>.*) end$`, false},
+		{`^(?P<a>[^ ]+) (?:(?P<b>\d+)|(?P<c>\w+))$`, false},
+	}
+	lines := append(generateLogLines(200, 0.8), exampleLines...)
+	lines = append(lines, "[x y z|w", "[x", "a b", " ", "")
+	for _, tt := range tests {
+		s, err := New(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (s.split != nil) != tt.split {
+			t.Errorf("%s: split plan %v, want %v", tt.pattern, s.split != nil, tt.split)
+		}
+		if s.split == nil {
+			continue
+		}
+		// The split plan scans like the full one.
+		vm, err := New(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		vm.split = nil
+		got, want := make([][]byte, s.numCols), make([][]byte, s.numCols)
+		for _, line := range lines {
+			if s.Scan([]byte(line), got) != vm.Scan([]byte(line), want) || !reflect.DeepEqual(got, want) {
+				t.Fatalf("%s on %q: split %q, want %q", tt.pattern, line, got, want)
+			}
+		}
+	}
+}
+
+func TestScannerVerify(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		line    string
+		wantOK  bool
+		reason  RejectReason
+	}{
+		{
+			name:    "conforming line",
+			pattern: `^(?P<ts>\d{4}-[^ ]+) (?P<level>\w+) (?P<msg>.+)`,
+			line:    "2023-01-01T10:00:00.123Z INFO Application started",
+			wantOK:  true,
+		},
+		{
+			name:    "too few separators",
+			pattern: `^(?P<ts>\d{4}-[^ ]+) (?P<level>\w+) (?P<msg>.+)`,
+			line:    "2023-01-01T10:00:00Z INFO",
+			reason:  RejectDelimiter,
+		},
+		{
+			name:    "field shorter than required",
+			pattern: `^(?P<ts>\d{4}-[^ ]+) (?P<level>\w+) (?P<msg>.+)`,
+			line:    "bad line without proper format",
+			reason:  RejectShortField,
+		},
+		{
+			name:    "empty required last field",
+			pattern: `^(?P<a>[^ ]+) (?P<b>.+)`,
+			line:    "alpha ",
+			reason:  RejectShortField,
+		},
+		{
+			name:    "missing prefix",
+			pattern: `^\[(?P<ts>[^\]]+)\] (?P<msg>.*)`,
+			line:    "10/Oct/2000 hello",
+			reason:  RejectPrefix,
+		},
+		{
+			name:    "missing anchored suffix",
+			pattern: `^(?P<a>[^ ]+) "(?P<b>[^"]*)"$`,
+			line:    `alpha "beta" trailing`,
+			reason:  RejectSuffix,
+		},
+		{
+			name:    "content mismatch",
+			pattern: `^(?P<ts>\d{4}-[^ ]+) (?P<level>\w+) (?P<msg>.+)`,
+			line:    "another malformed line",
+			reason:  RejectContent,
+		},
+		{
+			name:    "class mismatch",
+			pattern: `^(?P<ts>\d{4}-[^ ]+) (?P<level>\w+) (?P<status>\d+)$`,
+			line:    "2023-01-01T10:00:00Z INFO 20x",
+			reason:  RejectClass,
+		},
+		{
+			name:    "level outside word class",
+			pattern: `^(?P<ts>[^ ]+) (?P<level>\w+) (?P<msg>.+)`,
+			line:    "2023-01-01 IN-FO message",
+			reason:  RejectClass,
+		},
+		{
+			name:    "adjacent field too short",
+			pattern: `^(?P<status>\d+)(?P<unit>[a-z]+)$`,
+			line:    "ms",
+			reason:  RejectShortField,
+		},
+		{
+			name:    "unclosed bracket",
+			pattern: `^(?P<level>[A-Z]+) \[(?P<component>[a-z]{1,8})\] (?P<msg>.*)$`,
+			line:    "INFO [controller sync done",
+			reason:  RejectDelimiter,
+		},
+		{
+			name:    "no separator after a bracket",
+			pattern: `^(?P<level>[A-Z]+) \[(?P<component>[a-z]{1,8})\] (?P<msg>.*)$`,
+			line:    "INFO [controller]sync done",
+			reason:  RejectDelimiter,
+		},
+		{
+			name:    "empty brackets",
+			pattern: `^(?P<level>[A-Z]+) \[(?P<component>[a-z]{1,8})\] (?P<msg>.*)$`,
+			line:    "INFO [] sync done",
+			reason:  RejectShortField,
+		},
+		{
+			name:    "bracketed field outside its class",
+			pattern: `^(?P<level>[A-Z]+) \[(?P<component>[a-z]{1,8})\] (?P<msg>.*)$`,
+			line:    "INFO [Controller] sync done",
+			reason:  RejectClass,
+		},
+		{
+			name:    "bracketed field too long",
+			pattern: `^(?P<level>[A-Z]+) \[(?P<component>[a-z]{1,8})\] (?P<msg>.*)$`,
+			line:    "INFO [schedulers] sync done",
+			reason:  RejectClass,
+		},
+		{
+			name:    "missing open bracket",
+			pattern: `^(?P<level>[A-Z]+) \[(?P<component>[a-z]{1,8})\] (?P<msg>.*)$`,
+			line:    "INFO controller] sync done",
+			reason:  RejectDelimiter,
+		},
+		{
+			name:    "missing space run",
+			pattern: `^(?P<a>\w+) +(?P<b>\d+)$`,
+			line:    "abc",
+			reason:  RejectDelimiter,
+		},
+		{
+			name:    "tab where a space run is expected",
+			pattern: `^(?P<a>\w+) +(?P<b>\d+)$`,
+			line:    "abc\t12",
+			reason:  RejectDelimiter,
+		},
+		{
+			name:    "space run too short",
+			pattern: `^(?P<a>\w+) {2,}(?P<b>\d+)$`,
+			line:    "abc 12",
+			reason:  RejectDelimiter,
+		},
+		{
+			name:    "wrong literal between space runs",
+			pattern: `^(?P<a>\w+)\s+-\s+(?P<b>\d+)$`,
+			line:    "abc  + 12",
+			reason:  RejectDelimiter,
+		},
+		{
+			name:    "field before a space run outside its class",
+			pattern: `^(?P<a>\w+) +(?P<b>\d+)$`,
+			line:    "ab-c  12",
+			reason:  RejectClass,
+		},
+		{
+			name:    "space run the pattern does not end with",
+			pattern: `^(?P<a>\w+) +(?P<b>\d+)$`,
+			line:    "abc  12 ",
+			reason:  RejectClass,
+		},
+		{
+			name:    "unclosed quote",
+			pattern: `^"(?P<a>(?:[^"\\]|\\.)*)" (?P<b>\d+)$`,
+			line:    `"never closed\" 5`,
+			reason:  RejectDelimiter,
+		},
+		{
+			name:    "no separator after a quote",
+			pattern: `^"(?P<a>(?:[^"\\]|\\.)*)" (?P<b>\d+)$`,
+			line:    `"closed"5`,
+			reason:  RejectDelimiter,
+		},
+		{
+			name:    "escaped newline in quotes",
+			pattern: `^"(?P<a>(?:[^"\\]|\\.)*)" (?P<b>\d+)$`,
+			line:    "\"bad \\\n escape\" 5",
+			reason:  RejectContent,
+		},
+		{
+			name:    "enclosed string without quotes",
+			pattern: `^(?P<a>"(?:[^"\\]|\\.)*") (?P<b>\d+)$`,
+			line:    `unquoted 5`,
+			reason:  RejectContent,
+		},
+		{
+			name:    "field after an enclosed string outside its class",
+			pattern: `^(?P<a>"(?:[^"\\]|\\.)*") (?P<b>\d+)$`,
+			line:    `"a" x`,
+			reason:  RejectClass,
+		},
+		{
+			name:    "uncaptured segment outside its class",
+			pattern: `^(?P<a>\w+) \d+ (?P<b>\w+)$`,
+			line:    "x y z",
+			reason:  RejectClass,
+		},
+		{
+			name:    "empty uncaptured segment",
+			pattern: `^(?P<a>\w+) \d+ (?P<b>\w+)$`,
+			line:    "x  z",
+			reason:  RejectShortField,
+		},
+		{
+			name:    "missing uncaptured segment",
+			pattern: `^(?P<a>\w+) \d+ (?P<b>\w+)$`,
+			line:    "x 1",
+			reason:  RejectDelimiter,
+		},
+		{
+			name:    "uncaptured segment content mismatch",
+			pattern: `^(?P<a>\w+) (?:ab)+ (?P<b>\w+)$`,
+			line:    "x ef z",
+			reason:  RejectContent,
+		},
+		{
+			name:    "leading uncaptured segment outside its class",
+			pattern: `^\d+ (?P<msg>.*)$`,
+			line:    "v1 started",
+			reason:  RejectClass,
+		},
+		{
+			name:    "anchored suffix present",
+			pattern: `^(?P<a>[^ ]+) "(?P<b>[^"]*)"$`,
+			line:    `alpha "beta"`,
+			wantOK:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.pattern)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			s = s.WithOptions(Options{ZeroCopy: true, Verify: true})
+			out := make([][]byte, len(s.Schema().Fields()))
+			ok := s.Scan([]byte(tt.line), out)
+			if ok != tt.wantOK {
+				t.Fatalf("Scan = %v, want %v (reason %v)", ok, tt.wantOK, s.Reason())
+			}
+			if !ok && s.Reason() != tt.reason {
+				t.Fatalf("expected reason %v, got %v", tt.reason, s.Reason())
+			}
+		})
+	}
+}
+
+func TestScannerBranches(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		line    string
+		want    []any // string, or nil for an absent field
+		wantOK  bool
+	}{
+		{
+			name:    "optional port present",
+			pattern: `^(?P<host>[^: ]+)(?::(?P<port>\d+))? (?P<msg>.*)$`,
+			line:    "example.com:8080 hello",
+			want:    []any{"example.com", "8080", "hello"},
+			wantOK:  true,
+		},
+		{
+			name:    "optional port absent",
+			pattern: `^(?P<host>[^: ]+)(?::(?P<port>\d+))? (?P<msg>.*)$`,
+			line:    "example.com hello: world",
+			want:    []any{"example.com", nil, "hello: world"},
+			wantOK:  true,
+		},
+		{
+			name:    "optional request id backtracks",
+			pattern: `^(?P<ts>[^ ]+)(?: (?P<req>\d+))? (?P<msg>.*)$`,
+			line:    "2023-01-01 12345",
+			want:    []any{"2023-01-01", nil, "12345"},
+			wantOK:  true,
+		},
+		{
+			name:    "optional bracketed prefix",
+			pattern: `^(?:\[(?P<id>[^\]]+)\] )?(?P<level>[A-Z]+) (?P<msg>.*)$`,
+			line:    "[abc-1] INFO started",
+			want:    []any{"abc-1", "INFO", "started"},
+			wantOK:  true,
+		},
+		{
+			name:    "alternation picks second branch",
+			pattern: `^(?P<level>[A-Z]+) (?:user=(?P<user>\w+)|ip=(?P<ip>[0-9.]+)) (?P<msg>.*)$`,
+			line:    "WARN ip=10.0.0.1 denied",
+			want:    []any{"WARN", nil, "10.0.0.1", "denied"},
+			wantOK:  true,
+		},
+		{
+			name:    "no alternative matches",
+			pattern: `^(?P<level>[A-Z]+) (?:user=(?P<user>\w+)|ip=(?P<ip>[0-9.]+)) (?P<msg>.*)$`,
+			line:    "WARN host=db denied",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.pattern)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			out := make([][]byte, len(s.Schema().Fields()))
+			if ok := s.Scan([]byte(tt.line), out); ok != tt.wantOK {
+				t.Fatalf("Scan = %v, want %v", ok, tt.wantOK)
+			}
+			if !tt.wantOK {
+				return
+			}
+			for i, want := range tt.want {
+				if want == nil {
+					if out[i] != nil {
+						t.Fatalf("field %d: expected nil, got %q", i, out[i])
+					}
+					continue
+				}
+				if string(out[i]) != want.(string) {
+					t.Fatalf("field %d: expected %q, got %q", i, want, out[i])
+				}
+			}
+		})
+	}
+}
+
+func TestScannerBranchesAllocFree(t *testing.T) {
+	s, err := New(`^(?P<host>[^: ]+)(?::(?P<port>\d+))? (?P<msg>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([][]byte, len(s.Schema().Fields()))
+	lines := [][]byte{[]byte("a:1 x"), []byte("b y")}
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, line := range lines {
+			s.Scan(line, out)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %v", allocs)
+	}
+}
+
+func TestScannerQuotedBranches(t *testing.T) {
+	// The first alternative unescapes a before failing on n; what it wrote
+	// must not leak into the second.
+	s, err := New(`^(?:"(?P<a>(?:[^"\\]|\\.)*)" (?P<n>\d+)|"(?P<b>(?:[^"\\]|\\.)*)" (?P<w>[a-z]+))$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{ZeroCopy: true, Verify: true, Unescape: true})
+
+	line := []byte(`"x\"y" abc`)
+	out := make([][]byte, 4)
+	if !s.Scan(line, out) {
+		t.Fatalf("Scan rejected line: %v", s.Reason())
+	}
+	if out[0] != nil || out[1] != nil || string(out[2]) != `x"y` || string(out[3]) != "abc" {
+		t.Fatalf("Scan = %q", out)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		s.Scan(line, out)
+	})
+	if allocs != 0 {
+		t.Fatalf("Scan allocates %v times per line", allocs)
+	}
+}
+
+func TestScannerMode(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    Mode
+	}{
+		{`^(?P<ts>[^ ]+) (?P<level>\w+) (?P<msg>.+)`, ModeCompiled},
+		{`^(?P<ts>\d{4}-[^ ]+) (?P<level>\w+) (?P<msg>.+)$`, ModeCompiled},
+		{`^(?P<host>[^: ]+)(?::(?P<port>\d+))? (?P<msg>.*)$`, ModeCompiled},
+		{`(?P<ts>[^ ]+) (?P<level>[^ ]+) (?P<msg>.*)`, ModePartial},
+		{`^(?P<a>.+) (?P<b>.+)`, ModePartial},
+		{`^(?P<a>\w+) (?P<n>\d+)`, ModeCompiled},
+		{`^(?P<a>\w+) (?P<n>\d+|-)`, ModePartial},
+		{`^(?P<ip>\S+) \S+ (?P<user>\S+) (?P<rest>.*)`, ModeCompiled},
+		{`^(?P<ip>\S+) (?:\S+ )+(?P<user>\S+) (?P<rest>.*)`, ModeRegexp},
+		{`^(?:(?P<kv>\w+=\w+) )+(?P<msg>.*)`, ModeRegexp},
+		{`^(?P<a>\w+|-)(?P<b>\d+) (?P<c>.*)`, ModeRegexp},
+		{`^(?P<level>\w+) \[(?P<tag>[^\]]+)\](?P<rest>\S*)`, ModeCompiled},
+		{`^\[(?P<a>.+)\] (?P<b>\w+)$`, ModePartial},
+		{`^(?P<user>\S+) +(?P<pid>\d+) +(?P<cmd>.*)$`, ModeCompiled},
+		{`^(?P<a>.+) +(?P<b>\d+)$`, ModePartial},
+		{`^(?P<a>\S+) *(?P<b>\d+)$`, ModeRegexp},
+		{`^(?P<a>\S+) "(?P<req>(?:[^"\\]|\\.)*)" (?P<b>"(?:[^"]|"")*"), (?P<c>.*)$`, ModeCompiled},
+		{`^"(?P<a>(?:[^"]|"")*)""(?P<b>\w+)$`, ModePartial},
+		{`^(?P<a>\w+) ([^ ]+) (?P<b>\d+)$`, ModeCompiled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			s, err := New(tt.pattern)
+			if err != nil {
+				t.Fatalf("New: %v", err)
+			}
+			if s.Mode() != tt.want {
+				t.Fatalf("expected mode %v, got %v (warnings: %q)", tt.want, s.Mode(), s.warnings)
+			}
+		})
+	}
+}
+
+func TestScannerRegexpFallback(t *testing.T) {
+	pattern := `^(?P<ip>\S+) (?:(?P<user>\S+) )+\[(?P<ts>[^\]]+)\] "(?P<req>[^"]*)"`
+	s, err := New(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Mode() != ModeRegexp {
+		t.Fatalf("expected regexp fallback, got %v", s.Mode())
+	}
+
+	re := regexp.MustCompile(pattern)
+	out := make([][]byte, len(s.Schema().Fields()))
+	for _, line := range []string{
+		`127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326`,
+		`not an access log line`,
+	} {
+		want := ParseLine(line, re)
+		ok := s.Scan([]byte(line), out)
+		if ok != (want != nil) {
+			t.Fatalf("%q: Scan = %v, regexp matched = %v", line, ok, want != nil)
+		}
+		if !ok {
+			if s.Reason() != RejectNoMatch {
+				t.Fatalf("expected reason %v, got %v", RejectNoMatch, s.Reason())
+			}
+			continue
+		}
+		for i := range want {
+			if string(out[i]) != want[i] {
+				t.Fatalf("field %d: expected %q, got %q", i, want[i], out[i])
+			}
+		}
+	}
+}
+
+func TestScannerWithRegexp(t *testing.T) {
+	// The timestamp holds the space the partial plan splits at, so the
+	// plan rejects the line when it verifies.
+	pattern := `^(?P<ts>\d{4}-\d\d-\d\d[ T][\d:]+) (?P<level>[A-Z]+) (?P<msg>.*)$`
+	s, err := New(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Mode() != ModePartial {
+		t.Fatalf("expected a partial plan, got %v", s.Mode())
+	}
+	s.WithOptions(Options{ZeroCopy: true, Verify: true})
+	line := []byte("2023-01-01 10:00:00 INFO hello world")
+	out := make([][]byte, 3)
+	if s.Scan(line, out) {
+		t.Fatalf("partial plan accepted %q as %q", line, out)
+	}
+	if _, err := s.WithRegexp(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Mode() != ModeRegexp {
+		t.Fatalf("mode is %v after WithRegexp", s.Mode())
+	}
+	if !s.Scan(line, out) || string(out[0]) != "2023-01-01 10:00:00" || string(out[2]) != "hello world" {
+		t.Fatalf("Scan = %q (%v)", out, s.Reason())
+	}
+
+	tmpl, err := NewTemplate("{ts} {msg...}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tmpl.WithRegexp(); err == nil {
+		t.Error("WithRegexp succeeded for a template")
+	}
+}
+
+func TestScannerAllocFree(t *testing.T) {
+	tests := []struct {
+		pattern string
+		lines   []string
+	}{
+		{
+			pattern: `^(?P<ts>\d{4}-[^ ]+) (?P<level>\w+) (?P<msg>.+)$`,
+			lines:   []string{"2023-01-01T10:00:00.123Z INFO Application starting up", "bad line without proper format"},
+		},
+		{
+			pattern: `^(?P<user>\S+) +(?P<pid>\d+) +(?P<cmd>.*)$`,
+			lines:   []string{"www-data   4242   nginx: worker process"},
+		},
+		{
+			pattern: `^(?P<ip>\S+) \S+ (?P<user>\S+) "([^"]*)" (?P<status>\d+)$`,
+			lines:   []string{`10.0.0.1 - frank "GET / HTTP/1.0" 200`},
+		},
+	}
+	for _, tt := range tests {
+		s, err := New(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var lines [][]byte
+		for _, line := range tt.lines {
+			lines = append(lines, []byte(line))
+		}
+		out := make([][]byte, len(s.Schema().Fields()))
+		for _, opts := range []Options{
+			{ZeroCopy: true},
+			{ZeroCopy: true, Verify: true},
+			{ZeroCopy: true, Verify: true, Unescape: true},
+		} {
+			s.WithOptions(opts)
+			allocs := testing.AllocsPerRun(100, func() {
+				for _, line := range lines {
+					s.Scan(line, out)
+				}
+			})
+			if allocs != 0 {
+				t.Fatalf("%s %+v: expected no allocations, got %v", tt.pattern, opts, allocs)
+			}
+		}
+	}
+}
+
+func TestScannerPlan(t *testing.T) {
+	s, err := New(`^\[(?P<ts>[^\]]+)\] (?P<level>\w+)(?: req=(?P<req>\d+))? (?P<msg>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := s.Plan()
+	if plan.Mode != ModeCompiled {
+		t.Fatalf("expected compiled plan, got %v (warnings: %q)", plan.Mode, plan.Warnings)
+	}
+	if plan.Prefix != "[" {
+		t.Fatalf("expected prefix %q, got %q", "[", plan.Prefix)
+	}
+	if len(plan.Fields) != 2 {
+		t.Fatalf("expected ts followed by a branch, got %+v", plan.Fields)
+	}
+
+	ts := plan.Fields[0]
+	if ts.Name != "ts" || ts.Terminator != "bracket" || ts.Brackets != "[]" || ts.Delimiter != " " || ts.Class != `[^\]]` || ts.Optional {
+		t.Fatalf("unexpected ts instruction: %+v", ts)
+	}
+
+	branch := plan.Fields[1]
+	if branch.Terminator != "branch" || len(branch.Alternatives) != 2 {
+		t.Fatalf("unexpected branch instruction: %+v", branch)
+	}
+	present := branch.Alternatives[0].Fields
+	if len(present) != 3 || present[1].Name != "req" || !present[1].Optional || present[0].Optional {
+		t.Fatalf("unexpected first alternative: %+v", present)
+	}
+	if last := present[2]; !last.Last || last.Terminator != "rest" || !branch.Alternatives[0].AnchorEnd {
+		t.Fatalf("unexpected last instruction: %+v", last)
+	}
+
+	// Fields without a column keep their place in the plan.
+	s, err = New(`^(?P<a>\w+) \S+ (?P<b>\w+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := s.Plan().Fields; len(f) != 3 || f[1].Column != -1 || f[1].Name != "" || f[2].Column != 1 {
+		t.Fatalf("unexpected plan for an uncaptured segment: %+v", f)
+	}
+}
+
+func TestScannerPlanWarnings(t *testing.T) {
+	s, err := New(`(?P<a>.+)\b (?P<b>\d+|-)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan := s.Plan()
+	if plan.Mode != ModePartial {
+		t.Fatalf("expected partial plan, got %v", plan.Mode)
+	}
+	want := []string{
+		"assertion `\\b` is ignored",
+		`pattern is not anchored with ^; the scanner matches from the start of the line`,
+		`field "a" may contain its delimiter " "; the scanner splits at the first occurrence`,
+		`last field "b" is not end-anchored; it takes the rest of the line`,
+	}
+	if len(plan.Warnings) != len(want) {
+		t.Fatalf("expected %d warnings, got %q", len(want), plan.Warnings)
+	}
+	for i := range want {
+		if plan.Warnings[i] != want[i] {
+			t.Fatalf("warning %d: expected %q, got %q", i, want[i], plan.Warnings[i])
+		}
+	}
+
+	// Warnings name a field without a column by its expression.
+	s, err = New(`^(?P<a>\w+) .+ (?P<b>\w+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skip := "field `(?-s:.+)` may contain its delimiter \" \"; the scanner splits at the first occurrence"
+	if w := s.Plan().Warnings; len(w) != 1 || w[0] != skip {
+		t.Fatalf("expected warning %q, got %q", skip, w)
+	}
+}
+
+func TestScannerRuneDelimiters(t *testing.T) {
+	tests := []struct {
+		pattern string
+		line    string
+		want    []string
+	}{
+		{`^(?P<a>[^│]+)│(?P<b>[^│]+)│(?P<c>.*)$`, "héllo│wörld││x", []string{"héllo", "wörld", "│x"}},
+		{`^(?P<from>\w+) → (?P<to>\w+)$`, "a → b", []string{"a", "b"}},
+		{`^(?P<a>[^·]*)·(?P<b>.*)$`, "x\xc2y·z", []string{"x\xc2y", "z"}},
+		// the first byte of · is not a separator on its own
+		{`^(?P<a>[^·]*)·(?P<b>.*)$`, "é·z", []string{"é", "z"}},
+	}
+	for _, tt := range tests {
+		s, err := New(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Mode() != ModeCompiled {
+			t.Fatalf("%s: Mode = %v, warnings %q", tt.pattern, s.Mode(), s.Plan().Warnings)
+		}
+		for _, verify := range []bool{false, true} {
+			s.WithOptions(Options{ZeroCopy: true, Verify: verify})
+			out := make([][]byte, len(tt.want))
+			if !s.Scan([]byte(tt.line), out) {
+				t.Fatalf("%s on %q: Scan rejected line: %v", tt.pattern, tt.line, s.Reason())
+			}
+			for i, w := range tt.want {
+				if string(out[i]) != w {
+					t.Errorf("%s on %q: field %d = %q, want %q", tt.pattern, tt.line, i, out[i], w)
+				}
+			}
+		}
+	}
+
+	// Content that may contain the rune still gets the warning.
+	s, err := New(`^(?P<a>\S+)│(?P<b>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Mode() != ModePartial {
+		t.Errorf("Mode = %v, want partial", s.Mode())
+	}
+}
+
+func TestNewUnrepresentableLiterals(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{`^(?P<a>\w+)` + "\uFFFD" + `(?P<b>\w+)$`, "U+FFFD"},
+	}
+	for _, tt := range tests {
+		_, err := New(tt.pattern)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("New(%#q) error = %v, want %q", tt.pattern, err, tt.want)
+		}
+	}
+
+	// Plans that fall back to regexp never match the literal themselves.
+	if _, err := New(`(?i)^(?P<a>\w+) methods (?:(?P<b>\d+) )+$`); err != nil {
+		t.Errorf("New: %v", err)
+	}
+
+	// Nor do case-insensitive literals with too many spellings.
+	s, err := New(`(?i)^(?P<a>\S+) connection=(?P<b>\S+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Mode() != ModeRegexp || !strings.Contains(strings.Join(s.Plan().Warnings, "\n"), "too many spellings") {
+		t.Fatalf("Mode = %v, warnings %q", s.Mode(), s.Plan().Warnings)
+	}
+	out := make([][]byte, 2)
+	if !s.Scan([]byte("db1 CONNECTION=Closed"), out) || string(out[0]) != "db1" || string(out[1]) != "Closed" {
+		t.Fatalf("Scan = %q", out)
+	}
+}
+
+func TestScannerCaseInsensitiveLiterals(t *testing.T) {
+	tests := []struct {
+		pattern string
+		line    string
+		want    []string
+	}{
+		{`^(?P<a>\d+)(?i:x)(?P<b>\d+)$`, "12x34", []string{"12", "34"}},
+		{`^(?P<a>\d+)(?:x|X)(?P<b>\d+)$`, "12X34", []string{"12", "34"}},
+		{`(?i)^get (?P<path>\S+)$`, "gEt /index", []string{"/index"}},
+		{`(?i)^(?P<a>\d+) - (?P<b>[a-z]+)$`, "1 - AbC", []string{"1", "AbC"}},
+		// k also folds to the Kelvin sign, U+212A
+		{`^(?P<a>\d+)(?i:k)(?P<b>\d+)$`, "1\u212a2", []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		s, err := New(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Mode() != ModeCompiled {
+			t.Fatalf("%s: Mode = %v, warnings %q", tt.pattern, s.Mode(), s.Plan().Warnings)
+		}
+		for _, verify := range []bool{false, true} {
+			s.WithOptions(Options{ZeroCopy: true, Verify: verify})
+			out := make([][]byte, len(tt.want))
+			if !s.Scan([]byte(tt.line), out) {
+				t.Fatalf("%s on %q: Scan rejected line: %v", tt.pattern, tt.line, s.Reason())
+			}
+			for i, w := range tt.want {
+				if string(out[i]) != w {
+					t.Errorf("%s on %q: field %d = %q, want %q", tt.pattern, tt.line, i, out[i], w)
+				}
+			}
+		}
+	}
+}
+
+func TestScannerLastFieldSuffix(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		line    string
+		want    string // last field; "-" when a verifying scan rejects the line
+		fast    string // last field without Verify
+	}{
+		{"anchored", `^(?P<a>[^ ]+) (?P<b>[^"]*)"$`, `x abc"`, "abc", "abc"},
+		{"anchored with junk", `^(?P<a>[^ ]+) (?P<b>[^"]*)"$`, `x abc" junk`, "-", "abc"},
+		{"unanchored", `^(?P<a>[^ ]+) (?P<b>[^"]*)"`, `x abc" junk "more"`, "abc", "abc"},
+		{"unanchored missing", `^(?P<a>[^ ]+) (?P<b>[^"]*)"`, `x abc`, "-", "abc"},
+		{"may contain the suffix", `^(?P<a>[^ ]+) (?P<b>.*)"$`, `x a"b"`, `a"b`, `a"b`},
+		{"longer suffix", `^(?P<a>\w+) (?P<b>[^)]*)\)\.$`, `x (ab).`, "(ab", "(ab"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := New(tt.pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Mode() != ModeCompiled {
+				t.Fatalf("Mode = %v, warnings %q", s.Mode(), s.Plan().Warnings)
+			}
+			out := make([][]byte, 2)
+			s.WithOptions(Options{ZeroCopy: true})
+			s.Scan([]byte(tt.line), out)
+			if string(out[1]) != tt.fast {
+				t.Errorf("fast: b = %q, want %q", out[1], tt.fast)
+			}
+
+			s.WithOptions(Options{ZeroCopy: true, Verify: true})
+			ok := s.Scan([]byte(tt.line), out)
+			switch {
+			case tt.want == "-" && ok:
+				t.Errorf("verify: accepted line, b = %q", out[1])
+			case tt.want != "-" && !ok:
+				t.Errorf("verify: rejected line: %v", s.Reason())
+			case ok && string(out[1]) != tt.want:
+				t.Errorf("verify: b = %q, want %q", out[1], tt.want)
+			}
+		})
+	}
+}
+
+func TestScannerTrimCR(t *testing.T) {
+	tests := []struct {
+		pattern string
+		line    string
+	}{
+		{`^(?P<a>\w+) (?P<b>\d+)$`, "abc 42\r"},
+		{`^(?P<a>\w+) "(?P<b>[^"]*)"$`, "abc \"42\"\r"},
+		{`^(?:(?P<a>\w+) )+(?P<b>\d+)$`, "abc 42\r"}, // regexp fallback
+	}
+	for _, tt := range tests {
+		s, err := New(tt.pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make([][]byte, 2)
+		s.WithOptions(Options{ZeroCopy: true, Verify: true})
+		if s.Scan([]byte(tt.line), out) {
+			t.Errorf("%s: accepted %q without TrimCR", tt.pattern, tt.line)
+		}
+		s.WithOptions(Options{ZeroCopy: true, Verify: true, TrimCR: true})
+		if !s.Scan([]byte(tt.line), out) || string(out[0]) != "abc" || string(out[1]) != "42" {
+			t.Errorf("%s: Scan(%q) = %q (%v)", tt.pattern, tt.line, out, s.Reason())
+		}
+	}
+}
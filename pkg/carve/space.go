@@ -0,0 +1,142 @@
+package carve
+
+import (
+	"bytes"
+	"fmt"
+	"regexp/syntax"
+)
+
+// ============================================================
+// Whitespace runs
+// ============================================================
+
+// spaceRun is a greedy run of whitespace in a field's separator, such as
+// ` +`, `\s+` or `[ \t]*`, matching at least min bytes of set.
+type spaceRun struct {
+	set byteSet
+	min int
+}
+
+// spaceBytes holds every byte a whitespace run may consist of.
+var spaceBytes = func() byteSet {
+	var set byteSet
+	for _, c := range []byte("\t\n\v\f\r ") {
+		set.add(c)
+	}
+	return set
+}()
+
+// spaceRunOf recognizes n as a greedy, unbounded repetition of
+// whitespace bytes.
+func spaceRunOf(n *syntax.Regexp) (*spaceRun, bool) {
+	if n.Flags&syntax.NonGreedy != 0 {
+		return nil, false
+	}
+	run := &spaceRun{}
+	switch n.Op {
+	case syntax.OpPlus:
+		run.min = 1
+	case syntax.OpStar:
+	case syntax.OpRepeat:
+		if n.Max >= 0 {
+			return nil, false
+		}
+		run.min = n.Min
+	default:
+		return nil, false
+	}
+	set, ok := classSet(n.Sub[0])
+	if !ok || set == (byteSet{}) {
+		return nil, false
+	}
+	for i := range set {
+		if set[i]&^spaceBytes[i] != 0 {
+			return nil, false
+		}
+	}
+	run.set = set
+	return run, true
+}
+
+// String renders the run as a regex repetition.
+func (r *spaceRun) String() string {
+	switch r.min {
+	case 0:
+		return r.set.String() + "*"
+	case 1:
+		return r.set.String() + "+"
+	default:
+		return fmt.Sprintf("%s{%d,}", r.set.String(), r.min)
+	}
+}
+
+func sameRun(a, b *spaceRun) bool {
+	return a == b || a != nil && b != nil && *a == *b
+}
+
+// skip returns the length of the run at the start of b, or -1 if it is
+// shorter than the run requires.
+func (r *spaceRun) skip(b []byte) int {
+	n := r.set.span(b, -1)
+	if n < r.min {
+		return -1
+	}
+	return n
+}
+
+// index returns the position of the first member of s in b, or -1.
+func (s *byteSet) index(b []byte) int {
+	for i, c := range b {
+		if s.has(c) {
+			return i
+		}
+	}
+	return -1
+}
+
+// sepIndex returns where f's separator starts in b, or -1. A leading run
+// starts at the first of its bytes.
+func (f *fieldVM) sepIndex(b []byte) int {
+	if f.lead != nil {
+		return f.lead.set.index(b)
+	}
+	return indexDelim(b, f.delim)
+}
+
+// sepEnd returns the position after f's separator when it starts at pos:
+// the leading run, the delimiter and the trailing run, in that order. It
+// returns -1 if the separator is not there.
+func (f *fieldVM) sepEnd(line []byte, pos int) int {
+	if f.lead != nil {
+		n := f.lead.skip(line[pos:])
+		if n < 0 {
+			return -1
+		}
+		pos += n
+	}
+	if !bytes.HasPrefix(line[pos:], f.delim) {
+		return -1
+	}
+	pos += len(f.delim)
+	if f.trail != nil {
+		n := f.trail.skip(line[pos:])
+		if n < 0 {
+			return -1
+		}
+		pos += n
+	}
+	return pos
+}
+
+// skipSep is sepEnd for the fast path: a delimiter that does not follow
+// is looked for further on, and runs are skipped whatever their length.
+func (f *fieldVM) skipSep(line []byte, pos int) int {
+	if f.lead != nil {
+		pos += f.lead.set.span(line[pos:], -1)
+	}
+	pos = skipDelim(line, pos, f.delim)
+	if f.trail != nil {
+		pos += f.trail.set.span(line[pos:], -1)
+	}
+	return pos
+}
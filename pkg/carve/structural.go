@@ -0,0 +1,258 @@
+package carve
+
+import (
+	"bytes"
+	"math/bits"
+)
+
+// ============================================================
+// Structural index
+// ============================================================
+
+// ScanBatch can run in two stages, as simdjson does: the first marks, in
+// one bitmap word per 64-byte block of the buffer, every byte that may end
+// a field (delimiters, closing brackets and quotes), and the second walks
+// the lines finding each of them as the next marked position instead of
+// searching for it, writing the offsets of the fields as it goes.
+// LineOffsets runs the first stage on newlines alone.
+//
+// The first stage classifies bytes with two 16-entry tables indexed by
+// their low and high nibble. Each byte of the set gets its own bit in
+// both tables, so a byte is marked exactly when the two entries share a
+// bit, which limits the set to 8 bytes. On amd64 with AVX2 and on arm64
+// the tables are applied 32 or 16 bytes at a time with a byte shuffle.
+
+// maxStructural is the largest set of bytes a structural index marks.
+const maxStructural = 8
+
+// nibbleTables holds the low and high nibble tables of a byte set, in
+// the layout the assembly kernels load.
+type nibbleTables [32]byte
+
+// structuralTables builds the tables for set, reporting false if it has
+// more than maxStructural bytes.
+func structuralTables(set []byte) (t nibbleTables, ok bool) {
+	n := 0
+	for i, c := range set {
+		if bytes.IndexByte(set[:i], c) >= 0 {
+			continue
+		}
+		if n == maxStructural {
+			return t, false
+		}
+		t[c&15] |= 1 << n
+		t[16+c>>4] |= 1 << n
+		n++
+	}
+	return t, true
+}
+
+// has reports whether c is in the set.
+func (t *nibbleTables) has(c byte) bool {
+	return t[c&15]&t[16+c>>4] != 0
+}
+
+// indexBlocks marks the bytes of the set in each whole 64-byte block of
+// buf, writing bit i of index[k] for byte 64*k+i. It uses the assembly
+// kernel where the CPU has one.
+func indexBlocks(t *nibbleTables, buf []byte, index []uint64) {
+	if haveKernel {
+		indexBlocksKernel(t, buf, index)
+		return
+	}
+	indexBlocksGeneric(t, buf, index)
+}
+
+func indexBlocksGeneric(t *nibbleTables, buf []byte, index []uint64) {
+	for k := 0; k < len(buf)/64; k++ {
+		var word uint64
+		for i, c := range buf[k*64 : k*64+64] {
+			if t.has(c) {
+				word |= 1 << i
+			}
+		}
+		index[k] = word
+	}
+}
+
+// buildIndex marks the bytes of the set in buf, reusing index, and
+// returns it with one word per 64 bytes, the last partial block included.
+func buildIndex(t *nibbleTables, buf []byte, index []uint64) []uint64 {
+	words := (len(buf) + 63) / 64
+	if cap(index) < words {
+		index = make([]uint64, words)
+	}
+	index = index[:words]
+	whole := len(buf) &^ 63
+	indexBlocks(t, buf[:whole], index)
+	if tail := buf[whole:]; len(tail) > 0 {
+		var block [64]byte
+		copy(block[:], tail)
+		indexBlocks(t, block[:], index[words-1:])
+		// the padding may be in the set
+		index[words-1] &= 1<<len(tail) - 1
+	}
+	return index
+}
+
+// nextMarked returns the position of the first delim at or after from in
+// buf[:to], looking only where index marks its first byte, or -1.
+func nextMarked(index []uint64, buf []byte, from, to int, delim []byte) int {
+	if from >= to {
+		return -1
+	}
+	k := from >> 6
+	word := index[k] &^ (1<<(from&63) - 1)
+	for {
+		for ; word != 0; word &= word - 1 {
+			i := k<<6 | bits.TrailingZeros64(word)
+			if i >= to {
+				return -1
+			}
+			if buf[i] == delim[0] && (len(delim) == 1 || bytes.HasPrefix(buf[i:to], delim)) {
+				return i
+			}
+		}
+		if k++; k<<6 >= to {
+			return -1
+		}
+		word = index[k]
+	}
+}
+
+// indexTables returns the tables of the bytes that end the scanner's
+// fields, or false if ScanBatch has to use the per-line VM: when it
+// verifies, some column is not written on every line, or a field ends
+// other than at a delimiter, closing bracket or closing quote.
+func (s *Scanner) indexTables() (t nibbleTables, ok bool) {
+	if s.noIndex || s.mode == ModeRegexp || s.sparse || s.opts.Verify {
+		return t, false
+	}
+	var buf [2 * maxStructural]byte
+	set := buf[:0]
+	for i := range s.fields {
+		f := &s.fields[i]
+		switch {
+		case f.isLast:
+			continue
+		case f.alts != nil, f.byClass, f.lead != nil, f.trail != nil, len(f.delim) == 0 && f.pair == nil:
+			return t, false
+		case f.quotes != nil:
+			set = append(append(set, f.quotes...), f.delim[0])
+		case f.pair != nil:
+			set = append(set, f.pair[1])
+			if len(f.delim) > 0 {
+				set = append(set, f.delim[0])
+			}
+		default:
+			set = append(set, f.delim[0])
+		}
+		if len(set) > maxStructural {
+			return t, false
+		}
+	}
+	return structuralTables(set)
+}
+
+// scanIndexed is scanFast for a line buf[start:end] of a plan that
+// indexTables accepted, taking each delimiter and quote from the
+// structural index of buf. It appends the position of every column to
+// cols, -1 for those that are absent.
+func (s *Scanner) scanIndexed(buf []byte, index []uint64, start, end int, cols *ColumnOffsets) {
+	line := buf[:end]
+	pos := start
+	put := func(col, from, to int) {
+		if col >= 0 {
+			cols.Starts[col] = append(cols.Starts[col], int32(from))
+			cols.Ends[col] = append(cols.Ends[col], int32(to))
+		}
+	}
+	if len(s.prefix) > 0 && bytes.HasPrefix(line[pos:], s.prefix) {
+		pos += len(s.prefix)
+	}
+	for i := range s.fields {
+		f := &s.fields[i]
+		if f.isLast {
+			e := end
+			if x := s.lastEnd(f, line[pos:]); x >= 0 {
+				e = pos + x
+			}
+			if pos >= end {
+				e, pos = -1, -1
+			}
+			put(f.col, pos, e)
+			return
+		}
+		if f.quotes != nil {
+			if n := markedQuoteEnd(f, index, line, pos); n >= 0 {
+				put(f.col, pos, pos+n)
+				pos = skipMarked(index, line, pos+n, f.delim)
+				continue
+			}
+		}
+		stop := f.delim
+		if f.pair != nil {
+			stop = f.pair[1:]
+		}
+		idx := nextMarked(index, line, pos, end, stop)
+		if idx < 0 {
+			put(f.col, -1, -1)
+			pos = end
+			continue
+		}
+		put(f.col, pos, idx)
+		pos = idx + len(stop)
+		if f.pair != nil && len(f.delim) > 0 {
+			pos = skipMarked(index, line, pos, f.delim)
+		}
+	}
+}
+
+// skipMarked is skipDelim finding the delimiter in the structural index.
+func skipMarked(index []uint64, line []byte, pos int, delim []byte) int {
+	if len(delim) == 0 {
+		return pos
+	}
+	if next := nextMarked(index, line, pos, len(line), delim); next >= 0 {
+		return next + len(delim)
+	}
+	return len(line)
+}
+
+// markedQuoteEnd is f.quoteEnd(line[pos:]) finding the quotes in the
+// structural index.
+func markedQuoteEnd(f *fieldVM, index []uint64, line []byte, pos int) int {
+	q, from := f.quotes[0], pos
+	if f.enclosed {
+		if pos == len(line) || bytes.IndexByte(f.quotes, line[pos]) < 0 {
+			return -1
+		}
+		q, from = line[pos], pos+1
+	}
+	quote := [1]byte{q}
+	for i := from; ; {
+		if i = nextMarked(index, line, i, len(line), quote[:]); i < 0 {
+			return -1
+		}
+		if f.doubled {
+			if i+1 < len(line) && line[i+1] == q {
+				i += 2
+				continue
+			}
+		} else {
+			// as in closeQuote, an odd run of backslashes escapes it
+			k := i
+			for k > from && line[k-1] == '\\' {
+				k--
+			}
+			if (i-k)%2 != 0 {
+				i++
+				continue
+			}
+		}
+		if f.enclosed {
+			return i + 1 - pos
+		}
+		return i - pos
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !purego
+
+package carve
+
+import "golang.org/x/sys/cpu"
+
+var haveKernel = cpu.X86.HasAVX2
+
+// indexBlocksKernel is indexBlocks with AVX2, two 32-byte halves per
+// block.
+//
+//go:noescape
+func indexBlocksKernel(t *nibbleTables, buf []byte, index []uint64)
@@ -0,0 +1,57 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func indexBlocksKernel(t *nibbleTables, buf []byte, index []uint64)
+TEXT ·indexBlocksKernel(SB), NOSPLIT, $0-56
+	MOVQ	t+0(FP), AX
+	MOVQ	buf_base+8(FP), SI
+	MOVQ	buf_len+16(FP), CX
+	MOVQ	index_base+32(FP), DI
+	SHRQ	$6, CX
+	JZ	done
+
+	// Y0 and Y1 hold the low and high nibble tables in both lanes.
+	VBROADCASTI128	(AX), Y0
+	VBROADCASTI128	16(AX), Y1
+	MOVL	$0x0f, DX
+	MOVQ	DX, X2
+	VPBROADCASTB	X2, Y2
+	VPXOR	Y7, Y7, Y7
+
+loop:
+	VMOVDQU	(SI), Y3
+	VMOVDQU	32(SI), Y4
+
+	VPSRLW	$4, Y3, Y5
+	VPAND	Y2, Y3, Y3
+	VPAND	Y2, Y5, Y5
+	VPSHUFB	Y3, Y0, Y3
+	VPSHUFB	Y5, Y1, Y5
+	VPAND	Y3, Y5, Y3
+	VPCMPEQB	Y7, Y3, Y3
+	VPMOVMSKB	Y3, R8
+
+	VPSRLW	$4, Y4, Y6
+	VPAND	Y2, Y4, Y4
+	VPAND	Y2, Y6, Y6
+	VPSHUFB	Y4, Y0, Y4
+	VPSHUFB	Y6, Y1, Y6
+	VPAND	Y4, Y6, Y4
+	VPCMPEQB	Y7, Y4, Y4
+	VPMOVMSKB	Y4, R9
+
+	// The compares found the bytes outside the set.
+	SHLQ	$32, R9
+	ORQ	R9, R8
+	NOTQ	R8
+	MOVQ	R8, (DI)
+
+	ADDQ	$64, SI
+	ADDQ	$8, DI
+	DECQ	CX
+	JNZ	loop
+	VZEROUPPER
+
+done:
+	RET
@@ -0,0 +1,12 @@
+//go:build !purego
+
+package carve
+
+// NEON is part of every arm64 CPU Go supports.
+const haveKernel = true
+
+// indexBlocksKernel is indexBlocks with NEON, four 16-byte quarters per
+// block.
+//
+//go:noescape
+func indexBlocksKernel(t *nibbleTables, buf []byte, index []uint64)
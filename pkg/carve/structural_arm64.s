@@ -0,0 +1,66 @@
+//go:build !purego
+
+#include "textflag.h"
+
+// func indexBlocksKernel(t *nibbleTables, buf []byte, index []uint64)
+TEXT ·indexBlocksKernel(SB), NOSPLIT, $0-56
+	MOVD	t+0(FP), R0
+	MOVD	buf_base+8(FP), R1
+	MOVD	buf_len+16(FP), R2
+	MOVD	index_base+32(FP), R3
+	LSR	$6, R2, R2
+	CBZ	R2, done
+
+	// V0 and V1 hold the low and high nibble tables, V2 the nibble mask
+	// and V3 the weight of each byte within its half of the bitmap word.
+	VLD1	(R0), [V0.B16, V1.B16]
+	VMOVI	$15, V2.B16
+	MOVD	$0x8040201008040201, R4
+	VMOV	R4, V3.D[0]
+	VMOV	R4, V3.D[1]
+
+loop:
+	VLD1.P	64(R1), [V4.B16, V5.B16, V6.B16, V7.B16]
+
+	VUSHR	$4, V4.B16, V8.B16
+	VAND	V2.B16, V4.B16, V4.B16
+	VTBL	V4.B16, [V0.B16], V4.B16
+	VTBL	V8.B16, [V1.B16], V8.B16
+	VCMTST	V4.B16, V8.B16, V4.B16
+
+	VUSHR	$4, V5.B16, V8.B16
+	VAND	V2.B16, V5.B16, V5.B16
+	VTBL	V5.B16, [V0.B16], V5.B16
+	VTBL	V8.B16, [V1.B16], V8.B16
+	VCMTST	V5.B16, V8.B16, V5.B16
+
+	VUSHR	$4, V6.B16, V8.B16
+	VAND	V2.B16, V6.B16, V6.B16
+	VTBL	V6.B16, [V0.B16], V6.B16
+	VTBL	V8.B16, [V1.B16], V8.B16
+	VCMTST	V6.B16, V8.B16, V6.B16
+
+	VUSHR	$4, V7.B16, V8.B16
+	VAND	V2.B16, V7.B16, V7.B16
+	VTBL	V7.B16, [V0.B16], V7.B16
+	VTBL	V8.B16, [V1.B16], V8.B16
+	VCMTST	V7.B16, V8.B16, V7.B16
+
+	// Weigh the matches and add neighbours until each byte of the low
+	// doubleword sums eight of them, in order.
+	VAND	V3.B16, V4.B16, V4.B16
+	VAND	V3.B16, V5.B16, V5.B16
+	VAND	V3.B16, V6.B16, V6.B16
+	VAND	V3.B16, V7.B16, V7.B16
+	VADDP	V5.B16, V4.B16, V4.B16
+	VADDP	V7.B16, V6.B16, V6.B16
+	VADDP	V6.B16, V4.B16, V4.B16
+	VADDP	V4.B16, V4.B16, V4.B16
+	VMOV	V4.D[0], R5
+	MOVD.P	R5, 8(R3)
+
+	SUBS	$1, R2, R2
+	BNE	loop
+
+done:
+	RET
@@ -0,0 +1,9 @@
+//go:build purego || !(amd64 || arm64)
+
+package carve
+
+const haveKernel = false
+
+func indexBlocksKernel(t *nibbleTables, buf []byte, index []uint64) {
+	indexBlocksGeneric(t, buf, index)
+}
@@ -0,0 +1,134 @@
+package carve
+
+import (
+	"math/rand"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestStructuralTables(t *testing.T) {
+	sets := []string{"", " ", " \n\"", ",;|\t", "\x00\xff", " \"]\n,:=|", "AQaq0@`p"}
+	for _, set := range sets {
+		tables, ok := structuralTables([]byte(set))
+		if !ok {
+			t.Fatalf("structuralTables(%q) failed", set)
+		}
+		for c := 0; c < 256; c++ {
+			if want := strings.IndexByte(set, byte(c)) >= 0; tables.has(byte(c)) != want {
+				t.Errorf("set %q: has(%q) = %v", set, byte(c), !want)
+			}
+		}
+	}
+	if _, ok := structuralTables([]byte("abcdefghi")); ok {
+		t.Error("structuralTables accepted 9 bytes")
+	}
+	if _, ok := structuralTables([]byte("aabbccddeeffgghh")); !ok {
+		t.Error("structuralTables counted repeated bytes")
+	}
+}
+
+func TestBuildIndex(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	alphabet := []byte(" \n\",;]abcxyz\x00\xff")
+	for _, set := range []string{" ", " \n\"", ",;]\x00\xff"} {
+		tables, _ := structuralTables([]byte(set))
+		var index []uint64
+		for n := 0; n < 300; n++ {
+			buf := make([]byte, n)
+			for i := range buf {
+				buf[i] = alphabet[rng.Intn(len(alphabet))]
+			}
+			want := make([]uint64, (n+63)/64)
+			for i, c := range buf {
+				if strings.IndexByte(set, c) >= 0 {
+					want[i/64] |= 1 << (i % 64)
+				}
+			}
+
+			index = buildIndex(&tables, buf, index)
+			if !slices.Equal(index, want) {
+				t.Fatalf("set %q, %q: index %x, want %x", set, buf, index, want)
+			}
+			generic := make([]uint64, len(want))
+			indexBlocksGeneric(&tables, buf, generic)
+			if whole := n / 64; !slices.Equal(generic[:whole], want[:whole]) {
+				t.Fatalf("set %q, %q: generic index %x, want %x", set, buf, generic, want)
+			}
+		}
+	}
+}
+
+func TestScanBatchIndexed(t *testing.T) {
+	lines := append(generateLogLines(500, 0.8), generateComplexLogLines(200, 0.8)...)
+	lines = append(lines, exampleLines...)
+	lines = append(lines, "", " ", "a", "x y", strings.Repeat("z ", 100), "[INFO] \r", "a,b;c d end\r")
+	lines = append(lines, `1.2.3.4 "GET /a \"x\" b" 200 ok`, `x "unclosed 1 2`, `y "a\\" 5 z`, `z "a\\\" b" 6 w`,
+		`a,"q""x, y",rest`, `a,"open,rest`, `a,plain,rest`, `b,'single',rest`)
+	buf := []byte(strings.Join(lines, "\n"))
+	offsets := LineOffsets(nil, buf)
+
+	patterns := []string{
+		benchmarkPattern,
+		`^(?P<ts>[^ ]+) (?P<level>\w+) (?P<msg>.+)$`,
+		`^\[(?P<level>[A-Z]+)\] (?P<msg>.*)$`,
+		`^(?P<a>[^ ]+) \S+ (?P<b>[^ ]+) (?P<c>.*)`,
+		`^(?P<a>[^,]+),(?P<b>[^;]+);(?P<c>[^ ]*) (?P<d>.*)`,
+		`^(?P<ts>[^ ]+) (?P<msg>.*) end$`,
+		`^(?P<ts>[^ ]+) \[(?P<thread>[^\]]+)\] (?P<level>[^ ]+) (?P<logger>[^ ]+) - (?P<msg>.*)$`,
+		`^(?P<ts>[^ ]+) \[(?P<thread>[^\]]+)\](?P<rest>.*)`,
+		`^(?P<a>[^-]+)->(?P<b>[^ ]*) - (?P<c>.*)`,
+		`^(?P<a>[^ ]+) "(?P<req>(?:[^"\\]|\\.)*)" (?P<b>[^ ]+) (?P<c>.*)$`,
+		`^(?P<a>[^,]*),(?P<b>"(?:[^"]|"")*"|'(?:[^']|'')*'),(?P<c>.*)$`,
+	}
+	for _, pattern := range patterns {
+		for _, opts := range []Options{{ZeroCopy: true}, {TrimCR: true}} {
+			s, err := New(pattern)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.WithOptions(opts)
+			if _, ok := s.indexTables(); !ok {
+				t.Fatalf("%s: plan is not indexed: %+v", pattern, s.Plan())
+			}
+
+			var got, want ColumnOffsets
+			s.ScanBatch(buf, offsets, &got)
+			s.noIndex = true
+			s.ScanBatch(buf, offsets, &want)
+			s.noIndex = false
+			if !reflect.DeepEqual(got, want) {
+				for r := range want.Lines {
+					for c := range want.Starts {
+						if got.Starts[c][r] != want.Starts[c][r] || got.Ends[c][r] != want.Ends[c][r] {
+							t.Fatalf("%s %+v: line %q column %d: indexed [%d, %d), want [%d, %d)", pattern, opts,
+								lines[want.Lines[r]], c, got.Starts[c][r], got.Ends[c][r], want.Starts[c][r], want.Ends[c][r])
+						}
+					}
+				}
+				t.Fatalf("%s %+v: indexed rows differ", pattern, opts)
+			}
+		}
+	}
+}
+
+func TestScanBatchIndexedAllocs(t *testing.T) {
+	s, err := New(`^(?P<ts>[^ ]+) \[(?P<thread>[^\]]+)\] (?P<level>\w+) (?P<msg>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.indexTables(); !ok {
+		t.Fatal("plan is not indexed")
+	}
+	buf := []byte(strings.Join(generateComplexLogLines(100, 1), "\n"))
+	offsets := LineOffsets(nil, buf)
+	var cols ColumnOffsets
+	s.ScanBatch(buf, offsets, &cols)
+	allocs := testing.AllocsPerRun(100, func() {
+		s.ScanBatch(buf, offsets, &cols)
+	})
+	if allocs != 0 {
+		t.Fatalf("ScanBatch allocates %v times per batch", allocs)
+	}
+}
@@ -0,0 +1,152 @@
+package carve
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+)
+
+// ============================================================
+// Template front end
+// ============================================================
+
+// NewTemplate creates a scanner from a template such as
+//
+//	{ts} {level} {msg...}
+//
+// Text outside braces is matched literally; {{ and }} stand for literal
+// braces. Each {name} field runs up to the first occurrence of the text
+// that follows it; a field between quotes, as in "{request}", runs to the
+// first quote not escaped with a backslash, and one between brackets, as
+// in [{thread}], to the first closing bracket. The last field runs to the
+// end of the line, less any trailing text; written {name...} it may
+// contain the separator before it, otherwise a verifying scan rejects
+// lines where it does (for single-byte separators). A field named _, as
+// in {_}, matches like any other but is left out of the schema.
+//
+// The plan is built directly from the template, so the scanner is always
+// in ModeCompiled and verifying scans are exact.
+func NewTemplate(template string) (*Scanner, error) {
+	lits, fields, err := parseTemplate(template)
+	if err != nil {
+		return nil, err
+	}
+
+	var schemaFields []arrow.Field
+	cols := make([]int, len(fields))
+	seen := map[string]bool{}
+	for i, f := range fields {
+		if f.name == "_" {
+			cols[i] = -1
+			continue
+		}
+		field := columnField(f.name)
+		if seen[field.Name] {
+			return nil, fmt.Errorf("template: field %q appears more than once", field.Name)
+		}
+		seen[field.Name] = true
+		cols[i] = len(schemaFields)
+		schemaFields = append(schemaFields, field)
+	}
+	if len(schemaFields) == 0 {
+		return nil, fmt.Errorf("template: %q has only {_} fields", template)
+	}
+
+	prog := scanProg{prefix: []byte(lits[0]), anchorEnd: true}
+	last := len(fields) - 1
+	for i, f := range fields {
+		after := lits[i+1]
+		if f.rest && i != last {
+			return nil, fmt.Errorf("template: only the last field may be written {%s...}", f.name)
+		}
+		vm := fieldVM{col: cols[i], max: -1}
+		if pair := bracketPair([]byte(lits[i]), []byte(after), byteSet{}); pair != nil && i != last {
+			vm.pair = pair
+			vm.delim = []byte(after[1:])
+			prog.fields = append(prog.fields, vm)
+			continue
+		}
+		if q := templateQuote(lits[i], after); q != 0 {
+			vm.quotes = []byte{q}
+		}
+		if i == last {
+			vm.isLast = true
+			if sep := lits[i]; i > 0 && !f.rest && len(sep) == 1 && vm.quotes == nil {
+				vm.class.addRange(0, 0xff)
+				vm.class.remove(sep[0])
+				vm.hasClass = true
+			}
+			prog.fields = append(prog.fields, vm)
+			prog.suffix = []byte(after)
+			break
+		}
+		if after == "" {
+			return nil, fmt.Errorf("template: fields %q and %q have no separator between them", f.name, fields[i+1].name)
+		}
+		vm.delim = []byte(after)
+		prog.fields = append(prog.fields, vm)
+	}
+
+	s := &Scanner{
+		schema:   arrow.NewSchema(schemaFields, nil),
+		scanProg: prog,
+		numCols:  len(schemaFields),
+		mode:     ModeCompiled,
+		scratch:  make([][]byte, len(schemaFields)),
+		opts:     Options{ZeroCopy: true},
+	}
+	s.compactPlan()
+	return s, nil
+}
+
+// templateQuote returns the quote byte a field is enclosed in, or 0.
+func templateQuote(before, after string) byte {
+	if before == "" || after == "" || before[len(before)-1] != after[0] {
+		return 0
+	}
+	if q := after[0]; q == '"' || q == '\'' {
+		return q
+	}
+	return 0
+}
+
+type templateField struct {
+	name string
+	rest bool
+}
+
+// parseTemplate splits a template into its fields and the literals
+// around them: lits[0] precedes the first field and lits[i+1] follows
+// field i.
+func parseTemplate(t string) (lits []string, fields []templateField, err error) {
+	var lit strings.Builder
+	for i := 0; i < len(t); i++ {
+		switch c := t[i]; {
+		case c == '{' && strings.HasPrefix(t[i:], "{{"), c == '}' && strings.HasPrefix(t[i:], "}}"):
+			lit.WriteByte(c)
+			i++
+		case c == '}':
+			return nil, nil, fmt.Errorf("template: unmatched } at offset %d", i)
+		case c == '{':
+			end := strings.IndexByte(t[i:], '}')
+			if end < 0 {
+				return nil, nil, fmt.Errorf("template: unclosed { at offset %d", i)
+			}
+			name, rest := strings.CutSuffix(t[i+1:i+end], "...")
+			if !grokName.MatchString(name) {
+				return nil, nil, fmt.Errorf("template: invalid field name %q", t[i:i+end+1])
+			}
+			lits = append(lits, lit.String())
+			lit.Reset()
+			fields = append(fields, templateField{name: name, rest: rest})
+			i += end
+		default:
+			lit.WriteByte(c)
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil, fmt.Errorf("template: %q has no fields", t)
+	}
+	return append(lits, lit.String()), fields, nil
+}
@@ -0,0 +1,212 @@
+package carve
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNewTemplate(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  string
+		line      string
+		want      []string
+		unescaped []string // want with Options.Unescape, if it differs
+		reason    RejectReason
+	}{
+		{
+			name:     "space separated",
+			template: "{ts} {level} {msg...}",
+			line:     "2023-01-01T10:00:00Z INFO Application started",
+			want:     []string{"2023-01-01T10:00:00Z", "INFO", "Application started"},
+		},
+		{
+			name:     "multi-byte separators and prefix",
+			template: "<{pri}>{host} - {msg...}",
+			line:     "<13>web-1 - disk full - retrying",
+			want:     []string{"13", "web-1", "disk full - retrying"},
+		},
+		{
+			name:     "trailing literal",
+			template: "[{thread}] {level} ({logger})",
+			line:     "[main] WARN (com.example.App)",
+			want:     []string{"main", "WARN", "com.example.App"},
+		},
+		{
+			name:     "escaped braces",
+			template: "{{{key}}}={value}",
+			line:     "{a}=1",
+			want:     []string{"a", "1"},
+		},
+		{
+			name:     "empty fields",
+			template: "{a},{b},{c}",
+			line:     ",,",
+			want:     []string{"", "", ""},
+		},
+		{
+			name:     "bracketed field",
+			template: "{ts} [{thread}] {level} {msg...}",
+			line:     "2023-01-01T10:00:00Z [pool-1 thread-2] WARN slow] request",
+			want:     []string{"2023-01-01T10:00:00Z", "pool-1 thread-2", "WARN", "slow] request"},
+		},
+		{
+			name:      "quoted fields",
+			template:  `{ip} "{request}" {status} "{agent}"`,
+			line:      `10.0.0.1 "GET /q?s=\"a b\" HTTP/1.1" 200 "Mozilla/5.0 (X11; Linux)"`,
+			want:      []string{"10.0.0.1", `GET /q?s=\"a b\" HTTP/1.1`, "200", "Mozilla/5.0 (X11; Linux)"},
+			unescaped: []string{"10.0.0.1", `GET /q?s="a b" HTTP/1.1`, "200", "Mozilla/5.0 (X11; Linux)"},
+		},
+		{
+			name:     "skipped fields",
+			template: "{ts} {_} {level} {_} {msg...}",
+			line:     "2023-01-01T10:00:00Z 4242 WARN main slow request",
+			want:     []string{"2023-01-01T10:00:00Z", "WARN", "slow request"},
+		},
+		{
+			name:     "last field without ellipsis",
+			template: "{a} {b}",
+			line:     "x y z",
+			reason:   RejectClass,
+		},
+		{
+			name:     "missing separator",
+			template: "{a}|{b}|{c}",
+			line:     "x|y",
+			reason:   RejectDelimiter,
+		},
+		{
+			name:     "missing prefix",
+			template: "<{pri}>{msg...}",
+			line:     "13>hello",
+			reason:   RejectPrefix,
+		},
+		{
+			name:     "missing suffix",
+			template: "[{thread}]",
+			line:     "[main",
+			reason:   RejectSuffix,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewTemplate(tt.template)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s.Mode() != ModeCompiled {
+				t.Fatalf("Mode = %v", s.Mode())
+			}
+			for _, unescape := range []bool{false, true} {
+				s.WithOptions(Options{ZeroCopy: true, Verify: true, Unescape: unescape})
+
+				out := make([][]byte, len(s.Schema().Fields()))
+				ok := s.Scan([]byte(tt.line), out)
+				if tt.want == nil {
+					if ok || s.Reason() != tt.reason {
+						t.Fatalf("Scan = %v, reason %v; want rejection with %v", ok, s.Reason(), tt.reason)
+					}
+					continue
+				}
+				if !ok {
+					t.Fatalf("unescape=%v: Scan rejected line: %v", unescape, s.Reason())
+				}
+				want := tt.want
+				if unescape && tt.unescaped != nil {
+					want = tt.unescaped
+				}
+				if len(out) != len(want) {
+					t.Fatalf("schema has %d columns, want %d", len(out), len(want))
+				}
+				for i, w := range want {
+					if out[i] == nil || string(out[i]) != w {
+						t.Errorf("unescape=%v: field %d = %q, want %q", unescape, i, out[i], w)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestNewTemplateSchema(t *testing.T) {
+	s, err := NewTemplate("{ts} {level} {msg...}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range s.Schema().Fields() {
+		names = append(names, f.Name+":"+f.Type.String())
+	}
+	if got := strings.Join(names, ","); got != "ts:binary,level:binary,msg:binary" {
+		t.Fatalf("schema = %s", got)
+	}
+
+	// {_} fields take no column.
+	skip, err := NewTemplate("{ts} {_} {level} {_} {msg...}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names = names[:0]
+	for _, f := range skip.Schema().Fields() {
+		names = append(names, f.Name)
+	}
+	if got := strings.Join(names, ","); got != "ts,level,msg" {
+		t.Fatalf("schema with {_} fields = %s", got)
+	}
+
+	// Without verification the template scans like the equivalent pattern.
+	out := make([][]byte, 3)
+	s.Scan([]byte("a b c d"), out)
+	if string(out[0]) != "a" || string(out[1]) != "b" || string(out[2]) != "c d" {
+		t.Fatalf("Scan = %q", out)
+	}
+}
+
+func TestNewTemplateErrors(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{"no fields here", "has no fields"},
+		{"{a}{b}", `fields "a" and "b" have no separator`},
+		{"{msg...} {level}", "only the last field may be written {msg...}"},
+		{"{a} {a}", `field "a" appears more than once`},
+		{"{a} {b", "unclosed {"},
+		{"{a} b}", "unmatched }"},
+		{"{} {b}", `invalid field name "{}"`},
+		{"{a-b}", `invalid field name "{a-b}"`},
+		{"{_} {_...}", "has only {_} fields"},
+	}
+	for _, tt := range tests {
+		_, err := NewTemplate(tt.template)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("NewTemplate(%q) error = %v, want %q", tt.template, err, tt.want)
+		}
+	}
+}
+
+func TestNewTemplateAllocFree(t *testing.T) {
+	tests := []struct {
+		template string
+		line     string
+	}{
+		{"{ts} {level} {msg...}", "2023-01-01T10:00:00Z INFO Application started"},
+		{"{ts} [{thread}] {level} {msg...}", "2023-01-01T10:00:00Z [pool-1 thread-2] WARN slow] request"},
+	}
+	for _, tt := range tests {
+		s, err := NewTemplate(tt.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.WithOptions(Options{ZeroCopy: true, Verify: true})
+		line := []byte(tt.line)
+		out := make([][]byte, len(s.Schema().Fields()))
+		allocs := testing.AllocsPerRun(100, func() {
+			s.Scan(line, out)
+		})
+		if allocs != 0 {
+			t.Fatalf("%s: Scan allocates %v times per line", tt.template, allocs)
+		}
+	}
+}
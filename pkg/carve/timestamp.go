@@ -0,0 +1,411 @@
+package carve
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+// ============================================================
+// Timestamp columns
+// ============================================================
+
+// TimestampOptions say how the values of a timestamp column are parsed
+// and stored.
+type TimestampOptions struct {
+	// Layout is the format of the values:
+	//   - "" or "iso8601" for RFC 3339 and the ISO 8601 forms logs use,
+	//     parsed without the time package; time.RFC3339 and
+	//     time.RFC3339Nano mean the same
+	//   - "epoch_s", "epoch_ms", "epoch_us" or "epoch_ns" for a count of
+	//     seconds, milli-, micro- or nanoseconds since 1970, which may
+	//     have a fraction
+	//   - a strftime layout such as "%d/%b/%Y:%H:%M:%S %z" if it has a %;
+	//     its literal text must not read as a Go layout element, such as
+	//     Mon or 1
+	//   - a Go layout such as "02/Jan/2006:15:04:05 -0700" otherwise
+	Layout string
+	// Unit is the unit of the column. The zero value is arrow.Second,
+	// which drops fractions of a second.
+	Unit arrow.TimeUnit
+	// TimeZone is the IANA name of the column's time zone, UTC if empty.
+	// Values without an offset are taken to be in it.
+	TimeZone string
+}
+
+// timestampTypes are the timestamp column types of annotations and type
+// maps. The epoch types keep the unit of their values.
+var timestampTypes = map[string]TimestampOptions{
+	"timestamp": {Unit: arrow.Microsecond},
+	"epoch_s":   {Layout: "epoch_s", Unit: arrow.Second},
+	"epoch_ms":  {Layout: "epoch_ms", Unit: arrow.Millisecond},
+	"epoch_us":  {Layout: "epoch_us", Unit: arrow.Microsecond},
+	"epoch_ns":  {Layout: "epoch_ns", Unit: arrow.Nanosecond},
+}
+
+// layoutKey is the field metadata key holding the layout of a timestamp
+// column, when it is not ISO 8601.
+const layoutKey = "carve.layout"
+
+// timestampField returns the field of a timestamp column, checking opts.
+func timestampField(name string, opts TimestampOptions) (arrow.Field, error) {
+	if opts.Unit > arrow.Nanosecond {
+		return arrow.Field{}, fmt.Errorf("carve: timestamp %q: unknown unit %d", name, opts.Unit)
+	}
+	if opts.TimeZone == "" {
+		opts.TimeZone = "UTC"
+	}
+	if _, err := newTimestampParser(opts.Layout, opts.Unit, opts.TimeZone); err != nil {
+		return arrow.Field{}, fmt.Errorf("carve: timestamp %q: %w", name, err)
+	}
+	f := arrow.Field{Name: name, Type: &arrow.TimestampType{Unit: opts.Unit, TimeZone: opts.TimeZone}}
+	if opts.Layout != "" {
+		f.Metadata = arrow.NewMetadata([]string{layoutKey}, []string{opts.Layout})
+	}
+	return f, nil
+}
+
+// fieldLayout returns the layout of timestamp column f.
+func fieldLayout(f arrow.Field) string {
+	if i := f.Metadata.FindKey(layoutKey); i >= 0 {
+		return f.Metadata.Values()[i]
+	}
+	return ""
+}
+
+// SchemaWithTimestamp returns schema with the named field made a
+// timestamp column. It fails on an unknown field, unit or time zone, or a
+// strftime layout with a directive it does not know or literal text Go
+// would misread.
+func SchemaWithTimestamp(schema *arrow.Schema, name string, opts TimestampOptions) (*arrow.Schema, error) {
+	idx := schema.FieldIndices(name)
+	if len(idx) == 0 {
+		return nil, fmt.Errorf("carve: timestamp: no field %q", name)
+	}
+	f, err := timestampField(name, opts)
+	if err != nil {
+		return nil, err
+	}
+	fields := slices.Clone(schema.Fields())
+	for _, i := range idx {
+		fields[i] = f
+	}
+	return arrow.NewSchema(fields, nil), nil
+}
+
+// WithTimestamp makes the named column a timestamp column, which a Writer
+// fills with the parsed values; those that do not parse are null. Like
+// WithTypes, it applies to every column of a projected scanner and
+// leaves the scanner unchanged on error.
+func (s *Scanner) WithTimestamp(name string, opts TimestampOptions) (*Scanner, error) {
+	return s.retype(func(schema *arrow.Schema) (*arrow.Schema, error) {
+		return SchemaWithTimestamp(schema, name, opts)
+	})
+}
+
+// ============================================================
+// Timestamp parsing
+// ============================================================
+
+type timestampFormat uint8
+
+const (
+	formatISO8601 timestampFormat = iota
+	formatEpoch
+	formatLayout
+)
+
+// timestampParser parses the values of one timestamp column.
+type timestampParser struct {
+	format timestampFormat
+	layout string // Go layout, for formatLayout
+	epoch  int64  // units of the values per second, for formatEpoch
+	perSec int64  // units of the column per second
+	loc    *time.Location
+}
+
+func newTimestampParser(layout string, unit arrow.TimeUnit, zone string) (*timestampParser, error) {
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		return nil, err
+	}
+	p := &timestampParser{perSec: int64(time.Second / unit.Multiplier()), loc: loc}
+	switch layout {
+	case "", "iso8601", time.RFC3339, time.RFC3339Nano:
+		p.format = formatISO8601
+	case "epoch_s", "epoch_ms", "epoch_us", "epoch_ns":
+		p.format = formatEpoch
+		p.epoch = map[string]int64{"epoch_s": 1, "epoch_ms": 1e3, "epoch_us": 1e6, "epoch_ns": 1e9}[layout]
+	default:
+		p.format = formatLayout
+		p.layout = layout
+		if strings.Contains(layout, "%") {
+			if p.layout, err = strftimeLayout(layout); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return p, nil
+}
+
+func (p *timestampParser) parse(v []byte) (arrow.Timestamp, bool) {
+	var sec, nsec int64
+	var ok bool
+	switch p.format {
+	case formatISO8601:
+		sec, nsec, ok = parseISO8601(v, p.loc)
+	case formatEpoch:
+		sec, nsec, ok = parseEpoch(v, p.epoch)
+	default:
+		t, err := time.ParseInLocation(p.layout, string(v), p.loc)
+		sec, nsec, ok = t.Unix(), int64(t.Nanosecond()), err == nil
+	}
+	if !ok || sec > math.MaxInt64/p.perSec-1 || sec < math.MinInt64/p.perSec+1 {
+		return 0, false
+	}
+	return arrow.Timestamp(sec*p.perSec + nsec/(1e9/p.perSec)), true
+}
+
+// timestampBuilder is the builder of a timestamp column, with the parser
+// of its layout.
+type timestampBuilder struct {
+	*array.TimestampBuilder
+	p *timestampParser
+}
+
+func (b *timestampBuilder) appendValues(vals [][]byte) {
+	b.Reserve(len(vals))
+	for _, v := range vals {
+		if ts, ok := b.p.parse(v); ok {
+			b.Append(ts)
+		} else {
+			b.AppendNull()
+		}
+	}
+}
+
+// newColumnBuilder returns the builder of column f. It panics on a
+// timestamp column with an invalid layout or time zone, which
+// WithTimestamp would have rejected.
+func newColumnBuilder(mem memory.Allocator, f arrow.Field) array.Builder {
+	ts, ok := f.Type.(*arrow.TimestampType)
+	if !ok {
+		return array.NewBuilder(mem, f.Type)
+	}
+	p, err := newTimestampParser(fieldLayout(f), ts.Unit, ts.TimeZone)
+	if err != nil {
+		panic(fmt.Sprintf("carve: timestamp %q: %v", f.Name, err))
+	}
+	return &timestampBuilder{TimestampBuilder: array.NewTimestampBuilder(mem, ts), p: p}
+}
+
+// parseISO8601 parses 2006-01-02T15:04:05 with an optional fraction of
+// any length after a . or a , and a zone of Z, ±hh:mm, ±hhmm or ±hh. The
+// T may be a space; without a zone the time is in loc. It returns the
+// seconds and nanoseconds since 1970.
+func parseISO8601(v []byte, loc *time.Location) (sec, nsec int64, ok bool) {
+	if len(v) < 19 || v[4] != '-' || v[7] != '-' || v[13] != ':' || v[16] != ':' ||
+		v[10] != 'T' && v[10] != 't' && v[10] != ' ' {
+		return 0, 0, false
+	}
+	year, month, day := digits(v[0:4]), digits(v[5:7]), digits(v[8:10])
+	hour, minute, s := digits(v[11:13]), digits(v[14:16]), digits(v[17:19])
+	if year < 0 || month < 1 || month > 12 || day < 1 || day > daysIn(month, year) ||
+		hour < 0 || hour > 23 || minute < 0 || minute > 59 || s < 0 || s > 59 {
+		return 0, 0, false
+	}
+
+	v = v[19:]
+	if len(v) > 0 && (v[0] == '.' || v[0] == ',') {
+		n := 1
+		for scale := int64(1e8); n < len(v) && v[n]-'0' <= 9; n++ {
+			nsec += int64(v[n]-'0') * scale
+			scale /= 10
+		}
+		if n == 1 {
+			return 0, 0, false
+		}
+		v = v[n:]
+	}
+
+	offset := 0
+	switch {
+	case len(v) == 0:
+		if loc != time.UTC {
+			t := time.Date(year, time.Month(month), day, hour, minute, s, 0, loc)
+			return t.Unix(), nsec, true
+		}
+	case len(v) == 1 && (v[0] == 'Z' || v[0] == 'z'):
+	case v[0] == '+' || v[0] == '-':
+		var oh, om int
+		switch {
+		case len(v) == 3:
+			oh, om = digits(v[1:3]), 0
+		case len(v) == 5:
+			oh, om = digits(v[1:3]), digits(v[3:5])
+		case len(v) == 6 && v[3] == ':':
+			oh, om = digits(v[1:3]), digits(v[4:6])
+		default:
+			return 0, 0, false
+		}
+		if oh < 0 || oh > 23 || om < 0 || om > 59 {
+			return 0, 0, false
+		}
+		offset = oh*3600 + om*60
+		if v[0] == '-' {
+			offset = -offset
+		}
+	default:
+		return 0, 0, false
+	}
+	sec = daysSinceEpoch(year, month, day)*86400 + int64(hour*3600+minute*60+s-offset)
+	return sec, nsec, true
+}
+
+// digits returns the value of a run of decimal digits, or -1.
+func digits(v []byte) int {
+	n := 0
+	for _, c := range v {
+		if c-'0' > 9 {
+			return -1
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
+
+func daysIn(month, year int) int {
+	switch month {
+	case 2:
+		if year%4 == 0 && (year%100 != 0 || year%400 == 0) {
+			return 29
+		}
+		return 28
+	case 4, 6, 9, 11:
+		return 30
+	}
+	return 31
+}
+
+// daysSinceEpoch returns the number of days from 1970-01-01 to a date of
+// the proleptic Gregorian calendar, as in Howard Hinnant's
+// days_from_civil.
+func daysSinceEpoch(year, month, day int) int64 {
+	if month <= 2 {
+		year--
+	}
+	era := year / 400
+	if year < 0 {
+		era = (year - 399) / 400
+	}
+	yoe := year - era*400
+	doy := (153*((month+9)%12)+2)/5 + day - 1
+	doe := yoe*365 + yoe/4 - yoe/100 + doy
+	return int64(era)*146097 + int64(doe) - 719468
+}
+
+// parseEpoch parses a count of 1/perSec seconds since 1970, with an
+// optional sign and fraction.
+func parseEpoch(v []byte, perSec int64) (sec, nsec int64, ok bool) {
+	neg := len(v) > 0 && v[0] == '-'
+	if len(v) > 0 && (v[0] == '-' || v[0] == '+') {
+		v = v[1:]
+	}
+	whole, frac := v, []byte(nil)
+	if i := bytes.IndexByte(v, '.'); i >= 0 {
+		whole, frac = v[:i], v[i+1:]
+		if len(frac) == 0 {
+			return 0, 0, false
+		}
+	}
+	n, ok := parseUint(whole)
+	if !ok || n > math.MaxInt64 {
+		return 0, 0, false
+	}
+	unit := 1e9 / perSec // nanoseconds per unit of the value
+	sec, nsec = int64(n)/perSec, int64(n)%perSec*unit
+	for scale := unit / 10; len(frac) > 0; frac = frac[1:] {
+		d := int64(frac[0] - '0')
+		if d > 9 {
+			return 0, 0, false
+		}
+		nsec += d * scale
+		scale /= 10
+	}
+	if neg {
+		sec, nsec = -sec, -nsec
+		if nsec < 0 {
+			sec--
+			nsec += 1e9
+		}
+	}
+	return sec, nsec, true
+}
+
+// strftimeVerbs are the Go layout elements of the strftime directives.
+var strftimeVerbs = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'j': "002",
+	'H': "15", 'I': "03", 'M': "04", 'S': "05", 'f': "999999999", 'p': "PM",
+	'b': "Jan", 'h': "Jan", 'B': "January", 'a': "Mon", 'A': "Monday",
+	'z': "-0700", 'Z': "MST", 'F': "2006-01-02", 'T': "15:04:05", '%': "%",
+}
+
+// strftimeLayout translates a strftime layout to a Go one. %f, the
+// fraction, takes any number of digits, and must follow a . or a , as in
+// %S.%f. Literal text that Go would read as a layout element, such as
+// Mon, PM or a 1, is rejected, as is text that runs into a directive's
+// element, such as the uary of %buary.
+func strftimeLayout(layout string) (string, error) {
+	var b, want strings.Builder // the Go layout, and what it should format as
+	lit := 0                    // start of the current literal text in b
+	literal := func() error {
+		text := b.String()[lit:]
+		if layoutProbe.Format(text) != text {
+			return fmt.Errorf("literal %q in strftime layout %q would be read as a Go layout element", text, layout)
+		}
+		want.WriteString(text)
+		return nil
+	}
+	for i := 0; i < len(layout); i++ {
+		if layout[i] != '%' {
+			b.WriteByte(layout[i])
+			continue
+		}
+		if i++; i == len(layout) {
+			return "", fmt.Errorf("strftime layout %q ends with %%", layout)
+		}
+		verb, ok := strftimeVerbs[layout[i]]
+		if !ok {
+			return "", fmt.Errorf("unknown strftime directive %%%c in %q", layout[i], layout)
+		}
+		if err := literal(); err != nil {
+			return "", err
+		}
+		b.WriteString(verb)
+		lit = b.Len()
+		if layout[i] == 'f' {
+			// only a fraction after its separator
+			want.WriteString(layoutProbe.Format("." + verb)[1:])
+		} else {
+			want.WriteString(layoutProbe.Format(verb))
+		}
+	}
+	if err := literal(); err != nil {
+		return "", err
+	}
+	if layoutProbe.Format(b.String()) != want.String() {
+		return "", fmt.Errorf("literal text next to a directive in strftime layout %q would be read as part of a Go layout element", layout)
+	}
+	return b.String(), nil
+}
+
+// layoutProbe formats every Go layout element differently from the
+// element itself, so that formatting with it shows how Go reads a layout.
+var layoutProbe = time.Date(1999, time.November, 28, 10, 38, 47, 123456789, time.FixedZone("XYZ", 3*3600+30*60))
@@ -0,0 +1,219 @@
+package carve
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestParseISO8601(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+	tests := []struct {
+		in   string
+		loc  *time.Location
+		want string // RFC 3339, or "" if rejected
+	}{
+		{"2023-01-01T10:00:00Z", time.UTC, "2023-01-01T10:00:00Z"},
+		{"2023-01-01t10:00:00z", time.UTC, "2023-01-01T10:00:00Z"},
+		{"2023-01-01 10:00:00.123456789+02:00", time.UTC, "2023-01-01T08:00:00.123456789Z"},
+		{"2023-01-01T10:00:00,5-0130", time.UTC, "2023-01-01T11:30:00.5Z"},
+		{"2023-01-01T10:00:00.1234567891234-05", time.UTC, "2023-01-01T15:00:00.123456789Z"},
+		{"2024-02-29T00:00:00Z", time.UTC, "2024-02-29T00:00:00Z"},
+		{"1969-12-31T23:59:59.5Z", time.UTC, "1969-12-31T23:59:59.5Z"},
+		{"0001-01-01T00:00:00Z", time.UTC, "0001-01-01T00:00:00Z"},
+		{"2023-07-01T12:00:00", time.UTC, "2023-07-01T12:00:00Z"},
+		{"2023-07-01T12:00:00", paris, "2023-07-01T10:00:00Z"},
+		{"2023-01-01T12:00:00", paris, "2023-01-01T11:00:00Z"},
+		{"2023-02-29T00:00:00Z", time.UTC, ""},
+		{"2023-13-01T00:00:00Z", time.UTC, ""},
+		{"2023-01-01T24:00:00Z", time.UTC, ""},
+		{"2023-01-01T10:60:00Z", time.UTC, ""},
+		{"2023-01-01T10:00:00.Z", time.UTC, ""},
+		{"2023-01-01T10:00:00+2", time.UTC, ""},
+		{"2023-01-01T10:00:00+02:0", time.UTC, ""},
+		{"2023-01-01T10:00:00 UTC", time.UTC, ""},
+		{"2023-01-01X10:00:00Z", time.UTC, ""},
+		{"2023-1-01T10:00:00Z", time.UTC, ""},
+		{"", time.UTC, ""},
+	}
+	for _, tt := range tests {
+		sec, nsec, ok := parseISO8601([]byte(tt.in), tt.loc)
+		got := ""
+		if ok {
+			got = time.Unix(sec, nsec).UTC().Format(time.RFC3339Nano)
+		}
+		if got != tt.want {
+			t.Errorf("parseISO8601(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestParseEpoch(t *testing.T) {
+	tests := []struct {
+		in     string
+		perSec int64
+		sec    int64
+		nsec   int64
+		ok     bool
+	}{
+		{"1700000000", 1, 1700000000, 0, true},
+		{"1700000000.25", 1, 1700000000, 250000000, true},
+		{"1700000000123", 1e3, 1700000000, 123000000, true},
+		{"1700000000123.5", 1e3, 1700000000, 123500000, true},
+		{"1700000000123456", 1e6, 1700000000, 123456000, true},
+		{"1700000000123456789", 1e9, 1700000000, 123456789, true},
+		{"-1.5", 1, -2, 500000000, true},
+		{"-1500", 1e3, -2, 500000000, true},
+		{"+7", 1, 7, 0, true},
+		{"1.", 1, 0, 0, false},
+		{".5", 1, 0, 0, false},
+		{"1e3", 1, 0, 0, false},
+		{"", 1, 0, 0, false},
+		{"99999999999999999999", 1, 0, 0, false},
+	}
+	for _, tt := range tests {
+		sec, nsec, ok := parseEpoch([]byte(tt.in), tt.perSec)
+		if sec != tt.sec || nsec != tt.nsec || ok != tt.ok {
+			t.Errorf("parseEpoch(%q, %d) = %d, %d, %v, want %d, %d, %v", tt.in, tt.perSec, sec, nsec, ok, tt.sec, tt.nsec, tt.ok)
+		}
+	}
+}
+
+func TestStrftimeLayout(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"%d/%b/%Y:%H:%M:%S %z", "02/Jan/2006:15:04:05 -0700"},
+		{"%F %T.%f", "2006-01-02 15:04:05.999999999"},
+		{"%a %e %I%p 99%%", "Mon _2 03PM 99%"},
+		{"%d.%m.%Y %H:%M:%S,%f", "02.01.2006 15:04:05,999999999"},
+	}
+	for _, tt := range tests {
+		if got, err := strftimeLayout(tt.in); got != tt.want || err != nil {
+			t.Errorf("strftimeLayout(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+	for _, bad := range []string{
+		"%Y-%Q", "%Y%",
+		// literals Go reads as layout elements
+		"%Y-%m-%d at 1pm", "Mon %d %b", "%H:%M PM", "%Y-%m-%dT%H:%M:%SZ07", "%H:%M:%S.000",
+		// literals that run into a directive's element
+		"%buary %Y", "_%e/%m",
+		"%S%f",
+	} {
+		if _, err := strftimeLayout(bad); err == nil {
+			t.Errorf("strftimeLayout(%q): expected an error", bad)
+		}
+	}
+}
+
+func TestWriterTimestamps(t *testing.T) {
+	s, err := NewTemplate("{iso__timestamp} {ms__epoch_ms} [{clf}] {local}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WithTimestamp("clf", TimestampOptions{Layout: "%d/%b/%Y:%H:%M:%S %z", Unit: arrow.Second}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WithTimestamp("local", TimestampOptions{Layout: "2006-01-02 15:04", Unit: arrow.Millisecond, TimeZone: "America/New_York"}); err != nil {
+		t.Skip(err)
+	}
+	lines := [][]byte{
+		[]byte("2023-01-01T10:00:00.5Z 1672567200500 [01/Jan/2023:11:00:00 +0100] 2023-01-01 05:00"),
+		[]byte("yesterday x [01/Jan/2023] 2023-01-01"),
+	}
+	w := NewWriter(s.WithOptions(Options{ZeroCopy: true}).Schema(), memory.DefaultAllocator, 10)
+	if _, err := w.WriteLinesSIMD(lines, s); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Release()
+
+	const want = 1672567200 // 2023-01-01T10:00:00Z
+	for i, unit := range []arrow.TimeUnit{arrow.Microsecond, arrow.Millisecond, arrow.Second, arrow.Millisecond} {
+		col, ok := rec.Column(i).(*array.Timestamp)
+		if !ok {
+			t.Fatalf("column %s is %s", rec.ColumnName(i), rec.Column(i).DataType())
+		}
+		if typ := col.DataType().(*arrow.TimestampType); typ.Unit != unit {
+			t.Errorf("column %s has unit %s, want %s", rec.ColumnName(i), typ.Unit, unit)
+		}
+		got := col.Value(0).ToTime(unit)
+		exp := time.Unix(want, 0)
+		if i < 2 {
+			exp = exp.Add(500 * time.Millisecond)
+		}
+		if !got.Equal(exp) {
+			t.Errorf("column %s = %v, want %v", rec.ColumnName(i), got, exp)
+		}
+		if col.IsValid(1) {
+			t.Errorf("column %s parsed %v from a bad value", rec.ColumnName(i), col.Value(1))
+		}
+	}
+	if tz := rec.Column(3).DataType().(*arrow.TimestampType).TimeZone; tz != "America/New_York" {
+		t.Errorf("local has time zone %q", tz)
+	}
+
+	// ArrowWriter parses them the same way.
+	aw := NewArrowWriter(s.Schema(), memory.DefaultAllocator, 0)
+	aw.Append([]string{"2023-01-01T10:00:00.5Z", "1672567200500", "01/Jan/2023:11:00:00 +0100", "2023-01-01 05:00"})
+	aw.Append([]string{"yesterday", "x", "01/Jan/2023", "2023-01-01"})
+	arec := aw.Flush()
+	defer arec.Release()
+	if !array.RecordEqual(rec, arec) {
+		t.Fatalf("ArrowWriter record %v, want %v", arec, rec)
+	}
+}
+
+func TestWithTimestampErrors(t *testing.T) {
+	s, err := New(`^(?P<ts>\S+) (?P<msg>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		opts TimestampOptions
+	}{
+		{"nosuch", TimestampOptions{}},
+		{"ts", TimestampOptions{Layout: "%Y-%Q"}},
+		{"ts", TimestampOptions{TimeZone: "Mars/Olympus_Mons"}},
+		{"ts", TimestampOptions{Unit: 7}},
+	} {
+		if _, err := s.WithTimestamp(tt.name, tt.opts); err == nil {
+			t.Errorf("%s %+v: expected an error", tt.name, tt.opts)
+		}
+	}
+	if typ := s.Schema().Field(0).Type; typ != arrow.BinaryTypes.Binary {
+		t.Fatalf("failed WithTimestamp changed the schema: ts is %s", typ)
+	}
+}
+
+func TestTimestampAllocs(t *testing.T) {
+	vals := [][]byte{[]byte("2023-01-01T10:00:00.123Z"), []byte("2023-01-01 10:00:00+02:00"), []byte("bad"), nil}
+	epochs := [][]byte{[]byte("1672567200123"), []byte("-5"), []byte("x")}
+	iso := newColumnBuilder(memory.DefaultAllocator, arrow.Field{Type: &arrow.TimestampType{Unit: arrow.Microsecond, TimeZone: "UTC"}})
+	f, err := timestampField("ms", timestampTypes["epoch_ms"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	epoch := newColumnBuilder(memory.DefaultAllocator, f)
+	for _, b := range []array.Builder{iso, epoch} {
+		defer b.Release()
+		b.Reserve(1 << 16)
+	}
+	// Neither the ISO 8601 nor the epoch parser allocates.
+	allocs := testing.AllocsPerRun(100, func() {
+		appendValues(iso, vals, nil)
+		appendValues(epoch, epochs, nil)
+	})
+	if allocs != 0 {
+		t.Fatalf("appendValues allocates %v times per run", allocs)
+	}
+}
@@ -0,0 +1,303 @@
+package carve
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+)
+
+// ============================================================
+// Column types
+// ============================================================
+
+// columnTypes are the types a column can be given, by the names used in
+// group name annotations, type maps and serialized plans. int and float
+// are the grok type hints.
+var columnTypes = map[string]arrow.DataType{
+	"binary":  arrow.BinaryTypes.Binary,
+	"int8":    arrow.PrimitiveTypes.Int8,
+	"int16":   arrow.PrimitiveTypes.Int16,
+	"int32":   arrow.PrimitiveTypes.Int32,
+	"int64":   arrow.PrimitiveTypes.Int64,
+	"uint8":   arrow.PrimitiveTypes.Uint8,
+	"uint16":  arrow.PrimitiveTypes.Uint16,
+	"uint32":  arrow.PrimitiveTypes.Uint32,
+	"uint64":  arrow.PrimitiveTypes.Uint64,
+	"float32": arrow.PrimitiveTypes.Float32,
+	"float64": arrow.PrimitiveTypes.Float64,
+	"bool":    arrow.FixedWidthTypes.Boolean,
+	"int":     arrow.PrimitiveTypes.Int64,
+	"float":   arrow.PrimitiveTypes.Float64,
+}
+
+// typeAnnotation separates a group name from the type of its column, as
+// in (?P<status__int32>\d+).
+const typeAnnotation = "__"
+
+// columnField returns the field a named group or template field becomes:
+// a name ending in __ and a type name is a column of that type named by
+// the part before, anything else a binary column. The name is kept whole
+// when the suffix is not a type, so that names such as trace__id still
+// work.
+func columnField(name string) arrow.Field {
+	if i := strings.LastIndex(name, typeAnnotation); i > 0 {
+		if f, err := typedField(name[:i], name[i+len(typeAnnotation):]); err == nil {
+			return f
+		}
+	}
+	return arrow.Field{Name: name, Type: arrow.BinaryTypes.Binary}
+}
+
+// typedField returns the field of a column of the named type.
+func typedField(name, typ string) (arrow.Field, error) {
+	if dt, ok := columnTypes[typ]; ok {
+		return arrow.Field{Name: name, Type: dt}, nil
+	}
+	if opts, ok := timestampTypes[typ]; ok {
+		return timestampField(name, opts)
+	}
+	return arrow.Field{}, fmt.Errorf("carve: types: unknown type %q for field %q", typ, name)
+}
+
+// SchemaWithTypes returns schema with the fields named in types given
+// the type named there: binary, int8 to int64, uint8 to uint64, float32,
+// float64, bool, or the grok hints int and float; or timestamp, an ISO
+// 8601 time in microseconds, or epoch_s, epoch_ms, epoch_us or epoch_ns,
+// a count since 1970 in that unit. SchemaWithTimestamp takes other
+// timestamps. It fails on unknown fields or types.
+func SchemaWithTypes(schema *arrow.Schema, types map[string]string) (*arrow.Schema, error) {
+	fields := slices.Clone(schema.Fields())
+	for _, name := range slices.Sorted(maps.Keys(types)) {
+		idx := schema.FieldIndices(name)
+		if len(idx) == 0 {
+			return nil, fmt.Errorf("carve: types: no field %q", name)
+		}
+		f, err := typedField(name, types[name])
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range idx {
+			fields[i] = f
+		}
+	}
+	return arrow.NewSchema(fields, nil), nil
+}
+
+// WithTypes sets the types of the named columns, as a side-car to the
+// annotations in the pattern; see SchemaWithTypes for the type names.
+// Scanning is unaffected: a Writer built from the schema parses the
+// values of typed columns, and a value that does not parse is null. A
+// projected scanner keeps the types when its projection changes.
+//
+// It fails on unknown fields or types, leaving the scanner unchanged.
+func (s *Scanner) WithTypes(types map[string]string) (*Scanner, error) {
+	return s.retype(func(schema *arrow.Schema) (*arrow.Schema, error) {
+		return SchemaWithTypes(schema, types)
+	})
+}
+
+// retype replaces the scanner's schema, and that of every column if it is
+// projected, with the one f derives from it.
+func (s *Scanner) retype(f func(*arrow.Schema) (*arrow.Schema, error)) (*Scanner, error) {
+	all := s.schema
+	if s.base != nil {
+		all = s.base.schema
+	}
+	retyped, err := f(all)
+	if err != nil {
+		return nil, err
+	}
+	if s.base == nil {
+		s.schema = retyped
+		return s, nil
+	}
+	s.base.schema = retyped
+	fields := slices.Clone(s.schema.Fields())
+	for i := range fields {
+		fields[i] = retyped.Field(retyped.FieldIndices(fields[i].Name)[0])
+	}
+	s.schema = arrow.NewSchema(fields, nil)
+	return s, nil
+}
+
+// ============================================================
+// Value parsing
+// ============================================================
+
+// appendValues appends a column's values to b, a builder made by
+// newColumnBuilder. Binary values are copied as they are, nil ones as
+// nulls; others are parsed, and those that are nil or do not parse are
+// null. valid is only used for binary columns.
+func appendValues(b array.Builder, vals [][]byte, valid []bool) {
+	switch b := b.(type) {
+	case *array.BinaryBuilder:
+		if n := totalDataLen(vals); n > 0 {
+			b.ReserveData(n)
+		}
+		b.AppendValues(vals, valid)
+	case *array.Int8Builder:
+		appendParsed(b, vals, parseSigned[int8])
+	case *array.Int16Builder:
+		appendParsed(b, vals, parseSigned[int16])
+	case *array.Int32Builder:
+		appendParsed(b, vals, parseSigned[int32])
+	case *array.Int64Builder:
+		appendParsed(b, vals, parseSigned[int64])
+	case *array.Uint8Builder:
+		appendParsed(b, vals, parseUnsigned[uint8])
+	case *array.Uint16Builder:
+		appendParsed(b, vals, parseUnsigned[uint16])
+	case *array.Uint32Builder:
+		appendParsed(b, vals, parseUnsigned[uint32])
+	case *array.Uint64Builder:
+		appendParsed(b, vals, parseUnsigned[uint64])
+	case *array.Float32Builder:
+		appendParsed(b, vals, parseFloat32)
+	case *array.Float64Builder:
+		appendParsed(b, vals, parseFloat64)
+	case *array.BooleanBuilder:
+		appendParsed(b, vals, parseBool)
+	case *timestampBuilder:
+		b.appendValues(vals)
+	default:
+		panic(fmt.Sprintf("carve: no parser for %s columns", b.Type()))
+	}
+}
+
+type typedBuilder[T any] interface {
+	Append(T)
+	AppendNull()
+	Reserve(int)
+}
+
+func appendParsed[T any](b typedBuilder[T], vals [][]byte, parse func([]byte) (T, bool)) {
+	b.Reserve(len(vals))
+	for _, v := range vals {
+		if x, ok := parse(v); ok {
+			b.Append(x)
+		} else {
+			b.AppendNull()
+		}
+	}
+}
+
+// parseInt parses an optionally signed decimal integer.
+func parseInt(v []byte) (int64, bool) {
+	neg := false
+	if len(v) > 0 && (v[0] == '-' || v[0] == '+') {
+		neg = v[0] == '-'
+		v = v[1:]
+	}
+	u, ok := parseUint(v)
+	switch {
+	case !ok:
+		return 0, false
+	case neg && u <= 1<<63:
+		return -int64(u), true
+	case !neg && u < 1<<63:
+		return int64(u), true
+	}
+	return 0, false
+}
+
+// parseUint parses an unsigned decimal integer.
+func parseUint(v []byte) (uint64, bool) {
+	if len(v) == 0 {
+		return 0, false
+	}
+	var n uint64
+	for _, c := range v {
+		d := uint64(c - '0')
+		if d > 9 || n > (1<<64-1-d)/10 {
+			return 0, false
+		}
+		n = n*10 + d
+	}
+	return n, true
+}
+
+func parseSigned[T int8 | int16 | int32 | int64](v []byte) (T, bool) {
+	n, ok := parseInt(v)
+	return T(n), ok && int64(T(n)) == n
+}
+
+func parseUnsigned[T uint8 | uint16 | uint32 | uint64](v []byte) (T, bool) {
+	n, ok := parseUint(v)
+	return T(n), ok && uint64(T(n)) == n
+}
+
+func parseFloat32(v []byte) (float32, bool) {
+	if !isFloat(v) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(string(v), 32)
+	return float32(f), err == nil
+}
+
+func parseFloat64(v []byte) (float64, bool) {
+	if !isFloat(v) {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(string(v), 64)
+	return f, err == nil
+}
+
+// isFloat reports whether v is a decimal number, or NaN or Inf, which is
+// checked before strconv.ParseFloat is called because its errors
+// allocate. The string conversion does not: strconv copies the input it
+// keeps in errors.
+func isFloat(v []byte) bool {
+	if len(v) > 0 && (v[0] == '+' || v[0] == '-') {
+		v = v[1:]
+	}
+	for _, special := range []string{"nan", "inf", "infinity"} {
+		if strings.EqualFold(string(v), special) {
+			return true
+		}
+	}
+	v, n := skipDigits(v)
+	if len(v) > 0 && v[0] == '.' {
+		var frac int
+		v, frac = skipDigits(v[1:])
+		n += frac
+	}
+	if n == 0 {
+		return false
+	}
+	if len(v) > 0 && (v[0] == 'e' || v[0] == 'E') {
+		v = v[1:]
+		if len(v) > 0 && (v[0] == '+' || v[0] == '-') {
+			v = v[1:]
+		}
+		if v, n = skipDigits(v); n == 0 {
+			return false
+		}
+	}
+	return len(v) == 0
+}
+
+// skipDigits returns v after its leading decimal digits, and how many
+// there were.
+func skipDigits(v []byte) ([]byte, int) {
+	n := 0
+	for n < len(v) && v[n]-'0' <= 9 {
+		n++
+	}
+	return v[n:], n
+}
+
+// parseBool accepts what strconv.ParseBool does.
+func parseBool(v []byte) (bool, bool) {
+	switch string(v) {
+	case "1", "t", "T", "true", "TRUE", "True":
+		return true, true
+	case "0", "f", "F", "false", "FALSE", "False":
+		return false, true
+	}
+	return false, false
+}
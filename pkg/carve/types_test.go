@@ -0,0 +1,202 @@
+package carve
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
+	"github.com/apache/arrow-go/v18/arrow/memory"
+)
+
+func TestExtractSchemaTypes(t *testing.T) {
+	re := regexp.MustCompile(`^(?P<status__int32>\d+) (?P<took__float64>\S+) (?P<trace__id>\S+) (?P<ok__bool>\w+) (?P<msg__binary>.*)$`)
+	schema, err := ExtractSchema(re)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []arrow.Field{
+		{Name: "status", Type: arrow.PrimitiveTypes.Int32},
+		{Name: "took", Type: arrow.PrimitiveTypes.Float64},
+		// not a type, so part of the name
+		{Name: "trace__id", Type: arrow.BinaryTypes.Binary},
+		{Name: "ok", Type: arrow.FixedWidthTypes.Boolean},
+		{Name: "msg", Type: arrow.BinaryTypes.Binary},
+	}
+	if got := schema.Fields(); !arrow.NewSchema(got, nil).Equal(arrow.NewSchema(want, nil)) {
+		t.Fatalf("fields = %v, want %v", got, want)
+	}
+
+	for _, pattern := range []string{
+		`(?P<a>\d+) (?P<a__int8>\d+)`,
+		`(?P<a__int8>\d+) (?P<a__int16>\d+)`,
+	} {
+		if _, err := ExtractSchema(regexp.MustCompile(pattern)); err == nil {
+			t.Errorf("%s: expected an error for columns of the same name", pattern)
+		}
+	}
+}
+
+func TestTemplateTypes(t *testing.T) {
+	s, err := NewTemplate("{ts} {status__uint16} {msg...}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := s.Schema().Field(1); f.Name != "status" || f.Type != arrow.PrimitiveTypes.Uint16 {
+		t.Fatalf("field = %v", f)
+	}
+	if _, err := NewTemplate("{status} {status__int32}"); err == nil {
+		t.Fatal("expected an error for fields of the same name")
+	}
+}
+
+func TestScannerWithTypes(t *testing.T) {
+	s, err := New(`^(?P<status__int32>\d+) (?P<took>\S+) (?P<msg>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, types := range []map[string]string{
+		{"nosuch": "int32"},
+		{"took": "string"},
+		{"took": "float64", "msg": "decimal"},
+	} {
+		if _, err := s.WithTypes(types); err == nil {
+			t.Errorf("%v: expected an error", types)
+		}
+	}
+	if typ := s.Schema().Field(1).Type; typ != arrow.BinaryTypes.Binary {
+		t.Fatalf("failed WithTypes changed the schema: took is %s", typ)
+	}
+
+	// Types set on a projection apply to every column, and annotations
+	// can be overridden.
+	if _, err := s.WithProjection("took"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WithTypes(map[string]string{"took": "float", "status": "int64"}); err != nil {
+		t.Fatal(err)
+	}
+	if typ := s.Schema().Field(0).Type; typ != arrow.PrimitiveTypes.Float64 {
+		t.Fatalf("projected took is %s", typ)
+	}
+	if _, err := s.WithProjection(); err != nil {
+		t.Fatal(err)
+	}
+	want := []arrow.DataType{arrow.PrimitiveTypes.Int64, arrow.PrimitiveTypes.Float64, arrow.BinaryTypes.Binary}
+	for i, f := range s.Schema().Fields() {
+		if f.Type != want[i] {
+			t.Errorf("column %s is %s, want %s", f.Name, f.Type, want[i])
+		}
+	}
+}
+
+func TestWriterTypedColumns(t *testing.T) {
+	s, err := New(`^(?P<i8__int8>\S+) (?P<u64__uint64>\S+) (?P<f32__float32>\S+) (?P<ok__bool>\S+)(?: (?P<opt__int32>\d+))?$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{ZeroCopy: true})
+	lines := []string{
+		"-128 18446744073709551615 1.5 true 7",
+		"127 0 -2e3 F",
+		"128 18446744073709551616 x yes",
+		"- -1 NaN 1 2147483648",
+	}
+	w := NewWriter(s.Schema(), memory.DefaultAllocator, 100)
+	var batch [][]byte
+	for _, line := range lines {
+		batch = append(batch, []byte(line))
+	}
+	if _, err := w.WriteLinesSIMD(batch, s); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Release()
+
+	// the rows that do not parse are null
+	want := []string{
+		"[-128 127 (null) (null)]",
+		"[18446744073709551615 0 (null) (null)]",
+		"[1.5 -2000 (null) NaN]",
+		"[true false (null) true]",
+		"[7 (null) (null) (null)]",
+	}
+	for i, col := range rec.Columns() {
+		if got := col.String(); got != want[i] {
+			t.Errorf("column %s = %s, want %s", rec.ColumnName(i), got, want[i])
+		}
+	}
+
+	// ArrowWriter types its columns the same way.
+	aw := NewArrowWriter(s.Schema(), memory.DefaultAllocator, 0)
+	for _, line := range lines {
+		vals := strings.Split(line, " ")
+		aw.Append(append(vals, "")[:5])
+	}
+	arec := aw.Flush()
+	defer arec.Release()
+	if !array.RecordApproxEqual(rec, arec, array.WithNaNsEqual(true)) {
+		t.Fatalf("ArrowWriter record %v, want %v", arec, rec)
+	}
+}
+
+func TestParseIntegers(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+		ok   bool
+	}{
+		{"0", 0, true},
+		{"+42", 42, true},
+		{"-9223372036854775808", math.MinInt64, true},
+		{"9223372036854775807", math.MaxInt64, true},
+		{"9223372036854775808", 0, false},
+		{"-9223372036854775809", 0, false},
+		{"99999999999999999999", 0, false},
+		{"", 0, false},
+		{"-", 0, false},
+		{"1_000", 0, false},
+		{" 1", 0, false},
+	}
+	for _, tt := range tests {
+		if got, ok := parseInt([]byte(tt.in)); got != tt.want || ok != tt.ok {
+			t.Errorf("parseInt(%q) = %d, %v, want %d, %v", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+	if _, ok := parseSigned[int16]([]byte("32768")); ok {
+		t.Error("parseSigned[int16] accepted 32768")
+	}
+	if _, ok := parseUnsigned[uint32]([]byte("-1")); ok {
+		t.Error("parseUnsigned[uint32] accepted -1")
+	}
+	if got, ok := parseUnsigned[uint8]([]byte("255")); got != 255 || !ok {
+		t.Errorf("parseUnsigned[uint8](255) = %d, %v", got, ok)
+	}
+}
+
+func TestAppendValuesAllocs(t *testing.T) {
+	vals := [][]byte{[]byte("12"), []byte("-3.25"), []byte("true"), []byte("x"), nil}
+	builders := []array.Builder{
+		array.NewInt64Builder(memory.DefaultAllocator),
+		array.NewFloat64Builder(memory.DefaultAllocator),
+		array.NewBooleanBuilder(memory.DefaultAllocator),
+	}
+	for _, b := range builders {
+		defer b.Release()
+		b.Reserve(1 << 16)
+	}
+	// Parsing allocates nothing; the builders have room for every run.
+	allocs := testing.AllocsPerRun(100, func() {
+		for _, b := range builders {
+			appendValues(b, vals, nil)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("appendValues allocates %v times per run", allocs)
+	}
+}
@@ -1,10 +1,14 @@
 package carve
 
 import (
+	"bytes"
+	"os"
+	"reflect"
 	"regexp"
 	"testing"
 
 	"github.com/apache/arrow-go/v18/arrow"
+	"github.com/apache/arrow-go/v18/arrow/array"
 	"github.com/apache/arrow-go/v18/arrow/memory"
 )
 
@@ -197,3 +201,117 @@ func TestArrowWriterEdgeCases(t *testing.T) {
 		rec.Release()
 	})
 }
+
+func TestWriterVerifyDropsMalformedLines(t *testing.T) {
+	lines := [][]byte{
+		[]byte("2023-01-01T10:00:00.123Z INFO Application starting up"),
+		[]byte("bad line without proper format"),
+		[]byte("2023-01-01T10:00:01.456Z WARN Configuration file not found"),
+		[]byte("malformed"),
+	}
+
+	s, err := New(`^(?P<ts>\d{4}-[^ ]+) (?P<level>\w+) (?P<msg>.+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = s.WithOptions(Options{ZeroCopy: true, Verify: true})
+
+	w := NewWriter(s.Schema(), memory.DefaultAllocator, 100)
+	if _, err := w.WriteLinesSIMD(lines, s); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Release()
+	if rec.NumRows() != 2 {
+		t.Fatalf("expected 2 rows, got %d", rec.NumRows())
+	}
+}
+
+func TestWriterVerifySampleLog(t *testing.T) {
+	data, err := os.ReadFile("../../testdata/sample.log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.Split(bytes.TrimSpace(data), []byte("\n"))
+
+	s, err := New(`^(?P<ts>[^ ]+) (?P<level>[A-Z]+) (?P<msg>.+)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = s.WithOptions(Options{ZeroCopy: true, Verify: true})
+
+	w := NewWriter(s.Schema(), memory.DefaultAllocator, 100)
+	if _, err := w.WriteLinesSIMD(lines, s); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Release()
+	if rec.NumRows() != 9 {
+		t.Fatalf("expected 9 rows, got %d", rec.NumRows())
+	}
+}
+
+func TestWriterLines(t *testing.T) {
+	s, err := New(`^(?P<level>[A-Z]+) (?P<msg>.*)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{ZeroCopy: true, Verify: true})
+	lines := [][]byte{[]byte("INFO a"), []byte("bad"), []byte("WARN b"), []byte("INFO c"), []byte("bad")}
+
+	// The batch fills at line 2, and the writer stops there.
+	w := NewWriter(s.Schema(), memory.DefaultAllocator, 2)
+	rec, err := w.WriteLinesSIMD(lines, s)
+	if err != nil || rec == nil {
+		t.Fatalf("WriteLinesSIMD = %v, %v", rec, err)
+	}
+	rec.Release()
+	if got := w.Lines(); !reflect.DeepEqual(got, []int{0, 2}) {
+		t.Fatalf("Lines = %v, want [0 2]", got)
+	}
+	if rec, err = w.WriteLinesSIMD(lines[3:], s); err != nil || rec != nil {
+		t.Fatalf("WriteLinesSIMD = %v, %v", rec, err)
+	}
+	if got := w.Lines(); !reflect.DeepEqual(got, []int{0}) {
+		t.Fatalf("Lines = %v, want [0]", got)
+	}
+}
+
+func TestWriterUnescapedFields(t *testing.T) {
+	s, err := New(`^(?P<a>\S+) "(?P<req>(?:[^"\\]|\\.)*)" (?P<b>\d+)$`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.WithOptions(Options{ZeroCopy: true, Unescape: true})
+	lines := [][]byte{
+		[]byte(`x "a\"b" 1`),
+		[]byte(`y "c\"d" 2`),
+		// longer than the lines before, so the buffer has to grow
+		[]byte(`z "a much longer \"quoted\" request than the others" 3`),
+		[]byte(`w "plain" 4`),
+	}
+	w := NewWriter(s.Schema(), memory.DefaultAllocator, 100)
+	if _, err := w.WriteLinesSIMD(lines, s); err != nil {
+		t.Fatal(err)
+	}
+	rec, err := w.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rec.Release()
+
+	// Every row keeps its own unescaped value until the batch is built.
+	col := rec.Column(1).(*array.Binary)
+	want := []string{`a"b`, `c"d`, `a much longer "quoted" request than the others`, "plain"}
+	for i, v := range want {
+		if got := col.ValueString(i); got != v {
+			t.Errorf("row %d req = %q, want %q", i, got, v)
+		}
+	}
+}